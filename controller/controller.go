@@ -1,35 +1,50 @@
 package controller
 
 import (
-	emitter "github.com/pejovski/catalog/emitter/amqp"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/segmentio/ksuid"
+
+	myerr "github.com/pejovski/catalog/error"
 	"github.com/pejovski/catalog/gateway/reviewing"
 	"github.com/pejovski/catalog/model"
 	"github.com/pejovski/catalog/repository"
 	"github.com/sirupsen/logrus"
 )
 
+// maxSnapshotAttempts bounds the writes retried because the product changed after its snapshot was taken
+const maxSnapshotAttempts = 3
+
 type Controller interface {
-	GetProduct(id string) (*model.Product, error)
-	GetProducts(category string) ([]*model.Product, error)
-	CreateProduct(p *model.Product) (id string, err error)
-	UpdateProduct(p *model.Product) error
-	DeleteProduct(id string) error
-	UpdateProductPrice(id string, price float32) error
-	UpdateRating(id string) error
+	GetProduct(ctx context.Context, id string) (*model.Product, error)
+	GetProducts(ctx context.Context, f model.Filter, page model.Page) (*model.ProductPage, error)
+	SearchProducts(ctx context.Context, query string, f model.Filter) (*model.SearchResult, error)
+	CreateProduct(ctx context.Context, p *model.Product) (id string, err error)
+	UpdateProduct(ctx context.Context, p *model.Product) error
+	DeleteProduct(ctx context.Context, id string) error
+	UpdateProductPrice(ctx context.Context, id string, price float32, v *model.Version) error
+	UpdateRating(ctx context.Context, id string) error
+	BulkProducts(ctx context.Context, ops []*model.BulkOperation) ([]*model.BulkResult, error)
 }
 
 type controller struct {
 	repository repository.Repository
-	emitter    emitter.Emitter
-	reviewing  reviewing.Gateway
+	// events are recorded in the outbox and published by the relay
+	outbox    repository.Outbox
+	reviewing reviewing.Gateway
+	validator Validator
 }
 
-func New(r repository.Repository, e emitter.Emitter, rev reviewing.Gateway) Controller {
-	return controller{repository: r, emitter: e, reviewing: rev}
+func New(r repository.Repository, o repository.Outbox, rev reviewing.Gateway, v Validator) Controller {
+	return controller{repository: r, outbox: o, reviewing: rev, validator: v}
 }
 
-func (c controller) GetProduct(id string) (*model.Product, error) {
-	p, err := c.repository.Get(id)
+func (c controller) GetProduct(ctx context.Context, id string) (*model.Product, error) {
+	p, err := c.repository.Get(ctx, id)
 	if err != nil {
 		logrus.Errorf("Failed to get product %s; Error: %s", id, err)
 		return nil, err
@@ -38,67 +53,387 @@ func (c controller) GetProduct(id string) (*model.Product, error) {
 	return p, nil
 }
 
-func (c controller) GetProducts(category string) ([]*model.Product, error) {
-	ps, err := c.repository.GetByCategory(category)
+func (c controller) GetProducts(ctx context.Context, f model.Filter, page model.Page) (*model.ProductPage, error) {
+	ps, err := c.repository.GetByFilter(ctx, f, page)
 	if err != nil {
-		logrus.Errorf("Failed to get products for category %s; Error: %s", category, err)
+		logrus.Errorf("Failed to get products for filter %+v; Error: %s", f, err)
 		return nil, err
 	}
 
 	return ps, nil
 }
 
-func (c controller) CreateProduct(p *model.Product) (id string, err error) {
-	return c.repository.Create(p)
+func (c controller) SearchProducts(ctx context.Context, query string, f model.Filter) (*model.SearchResult, error) {
+	sr, err := c.repository.Search(ctx, query, f)
+	if err != nil {
+		logrus.Errorf("Failed to search products for %s; Error: %s", query, err)
+		return nil, err
+	}
+
+	return sr, nil
 }
 
-func (c controller) UpdateProduct(p *model.Product) (err error) {
-	err = c.repository.Update(p)
+func (c controller) CreateProduct(ctx context.Context, p *model.Product) (id string, err error) {
+	if err = c.validator.NewProduct(p); err != nil {
+		return "", err
+	}
+
+	// the id is assigned here so the event can be recorded before the write
+	p.Id = ksuid.New().String()
+	e := createdEvent(p)
+	if err = c.record(ctx, e); err != nil {
+		return "", err
+	}
+
+	id, err = c.repository.Create(ctx, p)
+	c.settle(err, e)
 	if err != nil {
-		logrus.Errorf("Failed to update product %s; Error: %s", p.Id, err)
+		logrus.Errorf("Failed to create product; Error: %s", err)
+		return "", err
+	}
+
+	return id, nil
+}
+
+func (c controller) UpdateProduct(ctx context.Context, p *model.Product) (err error) {
+	if err = c.validator.Product(p); err != nil {
 		return err
 	}
 
-	go c.emitter.ProductUpdated(p.Id)
+	// the rating is kept by the update
+	err = c.change(ctx, model.EventProductUpdated, p.Id, p.Version,
+		func(s *model.Product) {
+			s.Name, s.Brand, s.Price, s.Category, s.Image = p.Name, p.Brand, p.Price, p.Category, p.Image
+		},
+		func(v *model.Version) error {
+			u := *p
+			u.Version = v
+			return c.repository.Update(ctx, &u)
+		},
+	)
+	if err != nil {
+		logrus.Errorf("Failed to update product %s; Error: %s", p.Id, err)
+		return err
+	}
 
 	return err
 }
 
-func (c controller) UpdateProductPrice(id string, price float32) (err error) {
-	err = c.repository.UpdatePrice(id, price)
+func (c controller) UpdateProductPrice(ctx context.Context, id string, price float32, v *model.Version) (err error) {
+	if err = c.validator.Price(price); err != nil {
+		return err
+	}
+
+	err = c.change(ctx, model.EventProductPriceUpdated, id, v,
+		func(s *model.Product) {
+			s.Price = price
+		},
+		func(v *model.Version) error {
+			return c.repository.UpdatePrice(ctx, id, price, v)
+		},
+	)
 	if err != nil {
 		logrus.Errorf("Failed to update price of product %s; Error: %s", id, err)
 		return err
 	}
 
-	go c.emitter.ProductPriceUpdated(id, price)
-
 	return err
 }
 
-func (c controller) DeleteProduct(id string) (err error) {
-	err = c.repository.Delete(id)
+func (c controller) DeleteProduct(ctx context.Context, id string) (err error) {
+	err = c.change(ctx, model.EventProductDeleted, id, nil, nil, func(*model.Version) error {
+		return c.repository.Delete(ctx, id)
+	})
 	if err != nil {
 		logrus.Errorf("Failed to delete product %s; Error: %s", id, err)
 		return
 	}
 
-	go c.emitter.ProductDeleted(id)
-
 	return
 }
 
-func (c controller) UpdateRating(id string) error {
-	rating, err := c.reviewing.Rating(id)
+// change writes a product and records the event of the write with the snapshots of the product
+// before and after it. apply turns the before snapshot into the after one, nil means a delete.
+// The write is conditional on the version of the before snapshot so the snapshot is exact,
+// unless the caller asked for a version a concurrent write is retried with a fresh snapshot.
+func (c controller) change(ctx context.Context, typ string, id string, v *model.Version, apply func(s *model.Product), write func(v *model.Version) error) error {
+	for attempt := 1; ; attempt++ {
+		before, err := c.repository.Get(ctx, id)
+		if err != nil {
+			return err
+		}
+
+		e := newEvent(typ, id)
+		e.Before = before
+		if apply != nil {
+			after := *before
+			apply(&after)
+			e.After = &after
+		}
+
+		if err = c.record(ctx, e); err != nil {
+			return err
+		}
+
+		wv := v
+		if wv == nil {
+			wv = before.Version
+		}
+
+		err = write(wv)
+		c.settle(err, e)
+
+		if errors.Is(err, myerr.ErrConflict) && v == nil && attempt < maxSnapshotAttempts {
+			logrus.Warnf("Product %s changed while being written, retrying", id)
+			continue
+		}
+
+		return err
+	}
+}
+
+func (c controller) UpdateRating(ctx context.Context, id string) error {
+	rating, err := c.reviewing.Rating(ctx, id)
 	if err != nil {
 		logrus.Errorf("Failed to get rating for product %s, Error: %s", id, err)
 		return err
 	}
 
-	if err = c.repository.UpdateRating(id, rating); err != nil {
+	if err = c.repository.UpdateRating(ctx, id, rating); err != nil {
 		logrus.Errorf("Failed to update rating for product %s, Error: %s", id, err)
 		return err
 	}
 
 	return nil
 }
+
+// BulkProducts executes the valid operations in bulk and emits an event for every successful one,
+// invalid operations and products breaking the rules are reported without being executed.
+// The updated and deleted products are read first so their events carry the snapshots around the change,
+// the writes are conditional on the versions read and a product changed in between is reported as a conflict.
+func (c controller) BulkProducts(ctx context.Context, ops []*model.BulkOperation) ([]*model.BulkResult, error) {
+	results := make([]*model.BulkResult, len(ops))
+
+	checked := []int{}
+	ids := []string{}
+
+	for i, op := range ops {
+		if err := validateBulkOperation(op); err != nil {
+			r := &model.BulkResult{Type: op.Type, Status: http.StatusBadRequest, Error: err.Error()}
+			if op.Product != nil {
+				r.Id = op.Product.Id
+			}
+			results[i] = r
+			continue
+		}
+		if err := c.validateBulkProduct(op); err != nil {
+			results[i] = &model.BulkResult{Type: op.Type, Id: op.Product.Id, Status: http.StatusUnprocessableEntity, Error: err.Error()}
+			continue
+		}
+		checked = append(checked, i)
+		if op.Type != model.OpCreate {
+			ids = append(ids, op.Product.Id)
+		}
+	}
+
+	snapshots, err := c.snapshots(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	valid := []*model.BulkOperation{}
+	positions := []int{}
+	// events of the valid operations, in the same order
+	events := []*model.Event{}
+
+	for _, i := range checked {
+		op := ops[i]
+
+		var e *model.Event
+		switch op.Type {
+		case model.OpCreate:
+			op.Product.Id = ksuid.New().String()
+			e = createdEvent(op.Product)
+		case model.OpUpdate, model.OpDelete:
+			before, ok := snapshots[op.Product.Id]
+			if !ok {
+				results[i] = &model.BulkResult{Type: op.Type, Id: op.Product.Id, Status: http.StatusNotFound, Error: "product not found"}
+				continue
+			}
+			op.Product.Version = before.Version
+
+			typ := model.EventProductDeleted
+			if op.Type == model.OpUpdate {
+				typ = model.EventProductUpdated
+			}
+			e = newEvent(typ, op.Product.Id)
+			e.Before = before
+			if op.Type == model.OpUpdate {
+				// the rating is kept by the update
+				after := *before
+				after.Name, after.Brand, after.Price, after.Category, after.Image = op.Product.Name, op.Product.Brand, op.Product.Price, op.Product.Category, op.Product.Image
+				e.After = &after
+			}
+		}
+
+		valid = append(valid, op)
+		positions = append(positions, i)
+		events = append(events, e)
+	}
+
+	if err := c.record(ctx, events...); err != nil {
+		return nil, err
+	}
+
+	rs, err := c.repository.Bulk(ctx, valid)
+	if err != nil {
+		c.settle(err, events...)
+		logrus.Errorf("Failed to execute bulk operations; Error: %s", err)
+		return nil, err
+	}
+
+	succeeded, failed := []*model.Event{}, []*model.Event{}
+
+	for i, r := range rs {
+		results[positions[i]] = r
+
+		if r.Error != "" {
+			failed = append(failed, events[i])
+			continue
+		}
+		succeeded = append(succeeded, events[i])
+	}
+
+	c.ready(succeeded...)
+	c.discard(failed...)
+
+	return results, nil
+}
+
+// snapshots reads the products by id, the missing ones are left out
+func (c controller) snapshots(ctx context.Context, ids []string) (map[string]*model.Product, error) {
+	snapshots := map[string]*model.Product{}
+	if len(ids) == 0 {
+		return snapshots, nil
+	}
+
+	ps, err := c.repository.GetByIds(ctx, ids)
+	if err != nil {
+		logrus.Errorf("Failed to read %d products of bulk operations; Error: %s", len(ids), err)
+		return nil, err
+	}
+
+	for _, p := range ps {
+		snapshots[p.Id] = p
+	}
+
+	return snapshots, nil
+}
+
+func (c controller) validateBulkProduct(op *model.BulkOperation) error {
+	switch op.Type {
+	case model.OpCreate:
+		return c.validator.NewProduct(op.Product)
+	case model.OpUpdate:
+		return c.validator.Product(op.Product)
+	}
+	return nil
+}
+
+func validateBulkOperation(op *model.BulkOperation) error {
+	switch op.Type {
+	case model.OpCreate:
+		if op.Product == nil {
+			return errors.New("product is required")
+		}
+	case model.OpUpdate:
+		if op.Product == nil || op.Product.Id == "" {
+			return errors.New("id and product are required")
+		}
+	case model.OpDelete:
+		if op.Product == nil || op.Product.Id == "" {
+			return errors.New("id is required")
+		}
+	default:
+		return fmt.Errorf("unknown operation %s", op.Type)
+	}
+	return nil
+}
+
+func newEvent(typ string, productId string) *model.Event {
+	return &model.Event{Id: ksuid.New().String(), Type: typ, ProductId: productId, CreatedAt: time.Now()}
+}
+
+// record adds the events to the outbox before the write, the write fails if they cannot be recorded
+func (c controller) record(ctx context.Context, events ...*model.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+
+	if err := c.outbox.Add(ctx, events...); err != nil {
+		logrus.Errorf("Failed to record %d events in the outbox; Error: %s", len(events), err)
+		return err
+	}
+
+	return nil
+}
+
+// createdEvent carries the snapshot of the created product, the rating is left out since it is never set on create
+func createdEvent(p *model.Product) *model.Event {
+	e := newEvent(model.EventProductCreated, p.Id)
+	e.After = &model.Product{Id: p.Id, Name: p.Name, Brand: p.Brand, Price: p.Price, Category: p.Category, Image: p.Image}
+	return e
+}
+
+// settle makes the events ready once their write succeeded and discards them when it surely failed.
+// It is done on its own context since the write cannot be undone when the request is canceled.
+// When the outcome of the write is unknown (e.g. a timeout) the events are left pending,
+// they are published after the grace period of the relay since the write may have been applied.
+func (c controller) settle(writeErr error, events ...*model.Event) {
+	switch {
+	case writeErr == nil:
+		c.ready(events...)
+	case rejected(writeErr):
+		c.discard(events...)
+	default:
+		logrus.Warnf("Outcome of the write of events %v is unknown, leaving them pending; Error: %s", eventIds(events), writeErr)
+	}
+}
+
+// rejected tells if the write surely was not applied
+func rejected(err error) bool {
+	return errors.Is(err, myerr.ErrNotFound) ||
+		errors.Is(err, myerr.ErrConflict) ||
+		errors.Is(err, myerr.ErrValidation) ||
+		errors.Is(err, myerr.ErrInvalid)
+}
+
+func (c controller) ready(events ...*model.Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	ids := eventIds(events)
+	if err := c.outbox.Ready(context.Background(), ids...); err != nil {
+		logrus.Errorf("Failed to make events %v ready; Error: %s", ids, err)
+	}
+}
+
+func (c controller) discard(events ...*model.Event) {
+	if len(events) == 0 {
+		return
+	}
+
+	ids := eventIds(events)
+	if err := c.outbox.Remove(context.Background(), ids...); err != nil {
+		logrus.Errorf("Failed to remove events %v of a failed write from the outbox; Error: %s", ids, err)
+	}
+}
+
+func eventIds(events []*model.Event) []string {
+	ids := make([]string, len(events))
+	for i, e := range events {
+		ids[i] = e.Id
+	}
+	return ids
+}
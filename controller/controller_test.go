@@ -0,0 +1,178 @@
+package controller
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	myerr "github.com/pejovski/catalog/error"
+	"github.com/pejovski/catalog/model"
+	"github.com/pejovski/catalog/repository"
+	"github.com/pejovski/catalog/repository/memory"
+)
+
+func TestUpdateProductPriceSnapshots(t *testing.T) {
+	ctx := context.Background()
+	r, o := memory.NewRepository(), memory.NewOutbox()
+	c := New(r, o, nil, NewValidator(nil))
+
+	id, _ := r.Create(ctx, &model.Product{Name: "Galaxy S10", Brand: "Samsung", Price: 800, Category: "phones"})
+	_ = r.UpdateRating(ctx, id, &model.Rating{Stars: 4.5, Customers: 10})
+
+	if err := c.UpdateProductPrice(ctx, id, 750, nil); err != nil {
+		t.Fatalf("Expected price to be updated, got %s", err)
+	}
+
+	events, _ := o.Unsent(ctx, time.Now(), 10)
+	if len(events) != 1 {
+		t.Fatalf("Expected one event, got %d", len(events))
+	}
+
+	e := events[0]
+	if e.Type != model.EventProductPriceUpdated || e.ProductId != id {
+		t.Errorf("Expected a price event of product %s, got %+v", id, e)
+	}
+	if e.Before.Price != 800 || e.After.Price != 750 || e.After.Stars != 4.5 {
+		t.Errorf("Expected the price to change from 800 to 750 keeping the rating, got %+v %+v", e.Before, e.After)
+	}
+}
+
+func TestUpdateProductPriceStaleVersion(t *testing.T) {
+	ctx := context.Background()
+	r, o := memory.NewRepository(), memory.NewOutbox()
+	c := New(r, o, nil, NewValidator(nil))
+
+	id, _ := r.Create(ctx, &model.Product{Name: "Galaxy S10", Brand: "Samsung", Price: 800, Category: "phones"})
+	p, _ := r.Get(ctx, id)
+	_ = r.UpdatePrice(ctx, id, 790, nil)
+
+	err := c.UpdateProductPrice(ctx, id, 750, p.Version)
+	if !errors.Is(err, myerr.ErrConflict) {
+		t.Fatalf("Expected a conflict, got %v", err)
+	}
+
+	if events, _ := o.Unsent(ctx, time.Now(), 10); len(events) != 0 {
+		t.Errorf("Expected the event of the failed write to be discarded, got %+v", events)
+	}
+}
+
+func TestDeleteProductSnapshot(t *testing.T) {
+	ctx := context.Background()
+	r, o := memory.NewRepository(), memory.NewOutbox()
+	c := New(r, o, nil, NewValidator(nil))
+
+	id, _ := r.Create(ctx, &model.Product{Name: "Galaxy S10", Brand: "Samsung", Price: 800, Category: "phones"})
+
+	if err := c.DeleteProduct(ctx, id); err != nil {
+		t.Fatalf("Expected product to be deleted, got %s", err)
+	}
+
+	events, _ := o.Unsent(ctx, time.Now(), 10)
+	if len(events) != 1 || events[0].Before == nil || events[0].Before.Name != "Galaxy S10" || events[0].After != nil {
+		t.Errorf("Expected a delete event with the product before it, got %+v", events)
+	}
+}
+
+func TestCreateProductEvent(t *testing.T) {
+	ctx := context.Background()
+	r, o := memory.NewRepository(), memory.NewOutbox()
+	c := New(r, o, nil, NewValidator(nil))
+
+	id, err := c.CreateProduct(ctx, &model.Product{Name: "Galaxy S10", Brand: "Samsung", Price: 800, Category: "phones"})
+	if err != nil {
+		t.Fatalf("Expected product to be created, got %s", err)
+	}
+
+	// ready events are unsent right away, unlike pending ones
+	events, _ := o.Unsent(ctx, time.Time{}, 10)
+	if len(events) != 1 || events[0].Type != model.EventProductCreated || events[0].ProductId != id || events[0].After.Id != id {
+		t.Errorf("Expected a ready create event of product %s, got %+v", id, events)
+	}
+}
+
+func TestBulkProductsCreateEvents(t *testing.T) {
+	ctx := context.Background()
+	r, o := memory.NewRepository(), memory.NewOutbox()
+	c := New(r, o, nil, NewValidator(nil))
+
+	results, err := c.BulkProducts(ctx, []*model.BulkOperation{
+		{Type: model.OpCreate, Product: &model.Product{Name: "Galaxy S10", Brand: "Samsung", Price: 800, Category: "phones"}},
+	})
+	if err != nil || len(results) != 1 || results[0].Error != "" {
+		t.Fatalf("Expected product to be created, got %+v %v", results, err)
+	}
+
+	events, _ := o.Unsent(ctx, time.Time{}, 10)
+	if len(events) != 1 || events[0].Type != model.EventProductCreated || events[0].ProductId != results[0].Id {
+		t.Errorf("Expected a ready create event of product %s, got %+v", results[0].Id, events)
+	}
+}
+
+func TestBulkProductsSnapshots(t *testing.T) {
+	ctx := context.Background()
+	r, o := memory.NewRepository(), memory.NewOutbox()
+	c := New(r, o, nil, NewValidator(nil))
+
+	updated, _ := r.Create(ctx, &model.Product{Name: "Galaxy S10", Brand: "Samsung", Price: 800, Category: "phones"})
+	_ = r.UpdateRating(ctx, updated, &model.Rating{Stars: 4.5, Customers: 10})
+	deleted, _ := r.Create(ctx, &model.Product{Name: "iPhone 11", Brand: "Apple", Price: 900, Category: "phones"})
+
+	results, err := c.BulkProducts(ctx, []*model.BulkOperation{
+		{Type: model.OpUpdate, Product: &model.Product{Id: updated, Name: "Galaxy S10", Brand: "Samsung", Price: 750, Category: "phones"}},
+		{Type: model.OpDelete, Product: &model.Product{Id: deleted}},
+		{Type: model.OpDelete, Product: &model.Product{Id: "missing"}},
+	})
+	if err != nil {
+		t.Fatalf("Expected bulk results, got %s", err)
+	}
+	if results[0].Error != "" || results[1].Error != "" || results[2].Status != http.StatusNotFound {
+		t.Errorf("Expected the update and the delete to succeed and the missing product not to be found, got %+v %+v %+v", results[0], results[1], results[2])
+	}
+
+	events, _ := o.Unsent(ctx, time.Time{}, 10)
+	if len(events) != 2 {
+		t.Fatalf("Expected two events, got %d", len(events))
+	}
+
+	u, d := events[0], events[1]
+	if u.Before == nil || u.Before.Price != 800 || u.After.Price != 750 || u.After.Stars != 4.5 {
+		t.Errorf("Expected the price to change from 800 to 750 keeping the rating, got %+v %+v", u.Before, u.After)
+	}
+	if d.Before == nil || d.Before.Category != "phones" || d.After != nil {
+		t.Errorf("Expected a delete event with the product before it, got %+v", d)
+	}
+}
+
+// timeoutRepository applies the writes but reports they timed out
+type timeoutRepository struct {
+	repository.Repository
+}
+
+func (r timeoutRepository) Create(ctx context.Context, p *model.Product) (string, error) {
+	if _, err := r.Repository.Create(ctx, p); err != nil {
+		return "", err
+	}
+	return "", myerr.New(myerr.ErrTimeout, "create timed out")
+}
+
+func TestCreateProductTimeout(t *testing.T) {
+	ctx := context.Background()
+	o := memory.NewOutbox()
+	c := New(timeoutRepository{memory.NewRepository()}, o, nil, NewValidator(nil))
+
+	_, err := c.CreateProduct(ctx, &model.Product{Name: "Galaxy S10", Brand: "Samsung", Price: 800, Category: "phones"})
+	if !errors.Is(err, myerr.ErrTimeout) {
+		t.Fatalf("Expected a timeout, got %v", err)
+	}
+
+	// the write may have been applied so the event is left pending, it is unsent only after the grace period
+	if events, _ := o.Unsent(ctx, time.Time{}, 10); len(events) != 0 {
+		t.Errorf("Expected the event not to be ready, got %+v", events)
+	}
+	events, _ := o.Unsent(ctx, time.Now().Add(time.Second), 10)
+	if len(events) != 1 || events[0].Type != model.EventProductCreated {
+		t.Errorf("Expected the create event to be left pending in the outbox, got %+v", events)
+	}
+}
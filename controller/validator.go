@@ -0,0 +1,112 @@
+package controller
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	myerr "github.com/pejovski/catalog/error"
+	"github.com/pejovski/catalog/model"
+)
+
+const (
+	maxPrice      = 1000000
+	maxNameLength = 200
+)
+
+// Validator enforces the domain rules on products written by clients
+type Validator interface {
+	// NewProduct checks a product about to be created, the id is assigned by the catalog
+	NewProduct(p *model.Product) error
+	// Product checks an existing product about to be replaced
+	Product(p *model.Product) error
+	Price(price float32) error
+}
+
+type validator struct {
+	// nil accepts any category
+	categories map[string]bool
+}
+
+// NewValidator returns a validator accepting only the given categories, any category when there are none
+func NewValidator(categories []string) Validator {
+	v := validator{}
+
+	for _, c := range categories {
+		if c = strings.TrimSpace(c); c == "" {
+			continue
+		}
+		if v.categories == nil {
+			v.categories = map[string]bool{}
+		}
+		v.categories[c] = true
+	}
+
+	return v
+}
+
+func (v validator) NewProduct(p *model.Product) error {
+	var fe myerr.FieldErrors
+
+	if p.Id != "" {
+		fe = append(fe, myerr.FieldError{Field: "id", Code: "read_only", Message: "The id is assigned by the catalog"})
+	}
+
+	return v.invalid(append(fe, v.product(p)...))
+}
+
+func (v validator) Product(p *model.Product) error {
+	return v.invalid(v.product(p))
+}
+
+func (v validator) Price(price float32) error {
+	return v.invalid(v.price(price))
+}
+
+func (v validator) product(p *model.Product) myerr.FieldErrors {
+	var fe myerr.FieldErrors
+
+	fe = append(fe, v.text("name", p.Name)...)
+	fe = append(fe, v.text("brand", p.Brand)...)
+	fe = append(fe, v.price(p.Price)...)
+
+	switch {
+	case p.Category == "":
+		fe = append(fe, myerr.FieldError{Field: "category", Code: "required", Message: "The category is required"})
+	case v.categories != nil && !v.categories[p.Category]:
+		fe = append(fe, myerr.FieldError{Field: "category", Code: "unknown", Message: fmt.Sprintf("Category %s does not exist", p.Category)})
+	}
+
+	// the image is optional
+	if p.Image != "" {
+		if u, err := url.Parse(p.Image); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fe = append(fe, myerr.FieldError{Field: "image", Code: "invalid_url", Message: "The image must be an absolute http or https URL"})
+		}
+	}
+
+	return fe
+}
+
+func (v validator) text(field string, value string) myerr.FieldErrors {
+	switch {
+	case strings.TrimSpace(value) == "":
+		return myerr.FieldErrors{{Field: field, Code: "required", Message: fmt.Sprintf("The %s is required", field)}}
+	case len([]rune(value)) > maxNameLength:
+		return myerr.FieldErrors{{Field: field, Code: "too_long", Message: fmt.Sprintf("The %s must be at most %d characters", field, maxNameLength)}}
+	}
+	return nil
+}
+
+func (v validator) price(price float32) myerr.FieldErrors {
+	if price <= 0 || price > maxPrice {
+		return myerr.FieldErrors{{Field: "price", Code: "out_of_range", Message: fmt.Sprintf("The price must be greater than 0 and at most %d", maxPrice)}}
+	}
+	return nil
+}
+
+func (v validator) invalid(fe myerr.FieldErrors) error {
+	if len(fe) == 0 {
+		return nil
+	}
+	return myerr.Invalid(fe)
+}
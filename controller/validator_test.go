@@ -0,0 +1,71 @@
+package controller
+
+import (
+	"errors"
+	"testing"
+
+	myerr "github.com/pejovski/catalog/error"
+	"github.com/pejovski/catalog/model"
+)
+
+func TestValidatorNewProduct(t *testing.T) {
+	v := NewValidator([]string{"phones", " laptops"})
+
+	valid := model.Product{Name: "Galaxy S10", Brand: "Samsung", Price: 699.99, Category: "phones", Image: "https://cdn.example.com/s10.png"}
+
+	tests := []struct {
+		name   string
+		modify func(p *model.Product)
+		fields []string
+	}{
+		{"valid", func(p *model.Product) {}, nil},
+		{"trimmed category", func(p *model.Product) { p.Category = "laptops" }, nil},
+		{"no image", func(p *model.Product) { p.Image = "" }, nil},
+		{"client id", func(p *model.Product) { p.Id = "111" }, []string{"id"}},
+		{"empty", func(p *model.Product) { *p = model.Product{} }, []string{"name", "brand", "price", "category"}},
+		{"blank name", func(p *model.Product) { p.Name = "  " }, []string{"name"}},
+		{"negative price", func(p *model.Product) { p.Price = -1 }, []string{"price"}},
+		{"price too high", func(p *model.Product) { p.Price = maxPrice + 1 }, []string{"price"}},
+		{"unknown category", func(p *model.Product) { p.Category = "shoes" }, []string{"category"}},
+		{"relative image", func(p *model.Product) { p.Image = "/s10.png" }, []string{"image"}},
+		{"ftp image", func(p *model.Product) { p.Image = "ftp://cdn.example.com/s10.png" }, []string{"image"}},
+	}
+
+	for _, tt := range tests {
+		p := valid
+		tt.modify(&p)
+
+		err := v.NewProduct(&p)
+
+		if tt.fields == nil {
+			if err != nil {
+				t.Errorf("%s: unexpected error %s", tt.name, err)
+			}
+			continue
+		}
+
+		var fe myerr.FieldErrors
+		if !errors.Is(err, myerr.ErrInvalid) || !errors.As(err, &fe) {
+			t.Errorf("%s: expected field errors, got %v", tt.name, err)
+			continue
+		}
+
+		if len(fe) != len(tt.fields) {
+			t.Errorf("%s: expected errors for %v, got %s", tt.name, tt.fields, fe)
+			continue
+		}
+		for i, f := range tt.fields {
+			if fe[i].Field != f {
+				t.Errorf("%s: expected an error for %s, got %s", tt.name, f, fe[i].Field)
+			}
+		}
+	}
+}
+
+func TestValidatorAnyCategory(t *testing.T) {
+	p := &model.Product{Name: "Galaxy S10", Brand: "Samsung", Price: 699.99, Category: "anything"}
+
+	if err := NewValidator(nil).Product(p); err != nil {
+		t.Errorf("Expected any category to be accepted, got %s", err)
+	}
+}
@@ -2,140 +2,223 @@ package amqp
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
 	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
-	"sync"
+
+	emit "github.com/pejovski/catalog/emitter"
+	"github.com/pejovski/catalog/model"
+	"github.com/pejovski/catalog/pkg/rabbitmq"
 )
 
+// Messages published by the catalog, each to the exchange the topology maps it to and to its topic exchange
+var Messages = []string{model.EventProductCreated, model.EventProductUpdated, model.EventProductPriceUpdated, model.EventProductDeleted}
+
 const (
-	exProductUpdated      = "product_updated"
-	exProductDeleted      = "product_deleted"
-	exProductPriceUpdated = "product_price_updated"
+	DefaultConfirmTimeout = 5 * time.Second
 
-	exKind = "fanout"
+	// confirmations and returns arriving after their publish timed out wait here for the next publish,
+	// a full buffer would block the whole connection
+	notifyBuffer = 100
 )
 
-type Emitter interface {
-	ProductPriceUpdated(id string, price float32)
-	ProductUpdated(id string)
-	ProductDeleted(id string)
-}
+var (
+	// ErrNacked is returned when the broker refused the event, e.g. a queue is full
+	ErrNacked = errors.New("event nacked by rabbitmq")
+	// ErrUnroutable is returned when no queue is bound to the exchange of the event
+	ErrUnroutable = emit.ErrUnroutable
+	// ErrConfirmTimeout is returned when the broker did not confirm the event in time, it may have been accepted
+	ErrConfirmTimeout = errors.New("event confirm timeout")
+)
 
 type emitter struct {
-	ch   *amqp.Channel
-	once sync.Once
+	topology *rabbitmq.Topology
+	// channel of the current connection, nil while disconnected
+	channel *channel
+	// source of the events, e.g. catalog
+	source  string
+	timeout time.Duration
 }
 
-func NewEmitter(ch *amqp.Channel) Emitter {
-	return emitter{ch: ch}
+// channel is in confirm mode and used only for publishing, events are published one at a time
+// so the confirmation of an event is the one with the latest delivery tag
+type channel struct {
+	mu       sync.Mutex
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+	returns  chan amqp.Return
+	// delivery tag of the last publish
+	tag uint64
 }
 
-func (e emitter) ProductUpdated(id string) {
-	e.once.Do(e.declareExchange(exProductUpdated))
-
-	msg := struct {
-		Id string `json:"id"`
-	}{Id: id}
+// NewEmitter declares the topology and starts publishing on every connection, the topology must be valid
+// for the Messages. An event is emitted once the broker confirmed it, events emitted while disconnected fail
+// so the relay retries them.
+func NewEmitter(conn rabbitmq.Connection, t *rabbitmq.Topology, source string, confirmTimeout time.Duration) emit.Emitter {
+	e := emitter{topology: t, channel: &channel{}, source: source, timeout: confirmTimeout}
+	conn.OnConnect("emitter", e.connected)
+	return e
+}
 
-	b, err := json.Marshal(&msg)
-	if err != nil {
-		logrus.Errorf("Failed to json marshal product %s; Error: %s", id, err)
-		return
+func (e emitter) connected(ch *amqp.Channel) error {
+	if err := e.topology.Declare(ch); err != nil {
+		return err
 	}
 
-	if err = e.ch.Publish(
-		exProductUpdated,
-		"",
-		false,
-		false,
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "text/plain",
-			Body:         b,
-		}); err != nil {
-		logrus.Errorf("Failed publish event %s; Error: %s", string(b), err)
-		return
+	if err := ch.Confirm(false); err != nil {
+		logrus.Errorf("Failed to put the publishing channel in confirm mode; Error: %s", err)
+		return err
 	}
 
-	logrus.Infof("ProductUpdated event for product %s sent. Body: %s", id, string(b))
+	e.channel.mu.Lock()
+	defer e.channel.mu.Unlock()
+
+	e.channel.ch = ch
+	e.channel.confirms = ch.NotifyPublish(make(chan amqp.Confirmation, notifyBuffer))
+	e.channel.returns = ch.NotifyReturn(make(chan amqp.Return, notifyBuffer))
+	e.channel.tag = 0
+
+	return nil
+}
+
+func (e emitter) ProductCreated(ev *model.Event) error {
+	return e.emit(ev)
+}
+
+func (e emitter) ProductUpdated(ev *model.Event) error {
+	return e.emit(ev)
+}
+
+func (e emitter) ProductDeleted(ev *model.Event) error {
+	return e.emit(ev)
 }
 
-func (e emitter) ProductDeleted(id string) {
-	e.once.Do(e.declareExchange(exProductDeleted))
+func (e emitter) ProductPriceUpdated(ev *model.Event) error {
+	return e.emit(ev)
+}
 
-	msg := struct {
-		Id string `json:"id"`
-	}{Id: id}
+// emit publishes the event to the exchange of its type and to the topic exchange, whichever the topology has.
+// It is emitted once every publish is confirmed, when one fails the event is published to all of them again.
+// ErrUnroutable is returned when no queue is bound to the exchange of its type.
+func (e emitter) emit(ev *model.Event) error {
+	env := emit.NewEnvelope(e.source, ev)
 
-	b, err := json.Marshal(&msg)
+	b, err := json.Marshal(env)
 	if err != nil {
-		logrus.Errorf("Failed to json marshal product %s; Error: %s", id, err)
-		return
+		logrus.Errorf("Failed to json marshal event %s of product %s; Error: %s", ev.Id, ev.ProductId, err)
+		return err
 	}
 
-	if err = e.ch.Publish(
-		exProductDeleted,
-		"",
-		false,
-		false,
-		amqp.Publishing{
-			DeliveryMode: amqp.Persistent,
-			ContentType:  "text/plain",
-			Body:         b,
-		}); err != nil {
-		logrus.Errorf("Failed publish event %s; Error: %s", string(b), err)
-		return
+	// an unroutable event still goes to the topic exchange, the failure is returned after it
+	var unroutable error
+
+	if ex, ok := e.topology.Publish[ev.Type]; ok {
+		err = e.publish(ex, "", true, env, b)
+		switch {
+		case errors.Is(err, ErrUnroutable):
+			unroutable = err
+		case err != nil:
+			return err
+		}
 	}
 
-	logrus.Infof("ProductDeleted event for product %s sent. Body: %s", id, string(b))
-}
+	if e.topology.Topic != "" {
+		// consumers bind only the categories they are interested in, so the other events are unroutable on purpose
+		if err = e.publish(e.topology.Topic, emit.RoutingKey(ev), false, env, b); err != nil {
+			return err
+		}
+	}
 
-func (e emitter) ProductPriceUpdated(id string, price float32) {
-	e.once.Do(e.declareExchange(exProductPriceUpdated))
+	return unroutable
+}
 
-	msg := struct {
-		Id    string  `json:"id"`
-		Price float32 `json:"price"`
-	}{Id: id, Price: price}
+func (e emitter) publish(ex string, key string, mandatory bool, env *emit.Envelope, b []byte) error {
+	e.channel.mu.Lock()
+	defer e.channel.mu.Unlock()
 
-	b, err := json.Marshal(&msg)
-	if err != nil {
-		logrus.Errorf("Failed to json marshal product %s; Error: %s", id, err)
-		return
+	if e.channel.ch == nil {
+		return rabbitmq.ErrNotConnected
 	}
 
-	if err = e.ch.Publish(
-		exProductPriceUpdated,
-		"",
-		false,
+	// the attributes of the envelope are repeated as properties so consumers can route and dedup without decoding
+	if err := e.channel.ch.Publish(
+		ex,
+		key,
+		mandatory,
 		false,
 		amqp.Publishing{
 			DeliveryMode: amqp.Persistent,
-			ContentType:  "text/plain",
-			Body:         b,
+			ContentType:  emit.ContentType,
+			MessageId:    env.Id,
+			Timestamp:    env.Time,
+			Type:         env.Type,
+			AppId:        env.Source,
+			Headers: amqp.Table{
+				"cloudEvents:specversion":   env.SpecVersion,
+				"cloudEvents:subject":       env.Subject,
+				"cloudEvents:schemaversion": env.SchemaVersion,
+			},
+			Body: b,
 		}); err != nil {
 		logrus.Errorf("Failed publish event %s; Error: %s", string(b), err)
-		return
+		return err
+	}
+	e.channel.tag++
+
+	if err := e.confirm(ex, env.Id); err != nil {
+		logrus.Errorf("Event %s for product %s not accepted by %s; Error: %s", env.Type, env.Subject, ex, err)
+		return err
 	}
 
-	logrus.Infof("ProductPriceUpdated event for product %s sent. Body: %s", id, string(b))
+	logrus.Infof("Event %s for product %s sent to %s %s. Body: %s", env.Type, env.Subject, ex, key, string(b))
+
+	return nil
+}
+
+// confirm waits for the confirmation of the last publish, a mandatory event that could not be routed
+// is returned before it is acked
+func (e emitter) confirm(ex string, id string) error {
+	timeout := time.NewTimer(e.timeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case c, ok := <-e.channel.confirms:
+			if !ok {
+				return amqp.ErrClosed
+			}
+			// confirmations of earlier events which timed out are skipped
+			if c.DeliveryTag < e.channel.tag {
+				continue
+			}
+			if !c.Ack {
+				return ErrNacked
+			}
+			if e.returned(id) {
+				return fmt.Errorf("%w: no queue is bound to exchange %s", ErrUnroutable, ex)
+			}
+			return nil
+		case <-timeout.C:
+			return fmt.Errorf("%w after %s", ErrConfirmTimeout, e.timeout)
+		}
+	}
 }
 
-func (e emitter) declareExchange(ex string) func() {
-	return func() {
-		err := e.ch.ExchangeDeclare(
-			ex,
-			exKind,
-			true,
-			false,
-			false,
-			false,
-			nil,
-		)
-		if err != nil {
-			logrus.Errorf("%s %s: %s", "Failed to declare an exchange", ex, err)
-			return
+// returned drains the returns, the return of an event is always delivered before its confirmation
+func (e emitter) returned(id string) bool {
+	returned := false
+	for {
+		select {
+		case r := <-e.channel.returns:
+			if r.MessageId == id {
+				returned = true
+			}
+		default:
+			return returned
 		}
-		logrus.Infof("RabbitMq exchange %s declared", ex)
 	}
 }
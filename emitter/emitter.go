@@ -0,0 +1,20 @@
+package emitter
+
+import (
+	"errors"
+
+	"github.com/pejovski/catalog/model"
+)
+
+// ErrUnroutable is returned when the event was accepted but no queue is bound to its exchange,
+// retrying it is pointless until a consumer binds one
+var ErrUnroutable = errors.New("event unroutable")
+
+// Emitter publishes the product events other services depend on, each wrapped in an Envelope.
+// An error means the event may not have been published and should be retried, except ErrUnroutable.
+type Emitter interface {
+	ProductCreated(e *model.Event) error
+	ProductUpdated(e *model.Event) error
+	ProductPriceUpdated(e *model.Event) error
+	ProductDeleted(e *model.Event) error
+}
@@ -0,0 +1,124 @@
+package emitter
+
+import (
+	"strings"
+	"time"
+
+	"github.com/pejovski/catalog/model"
+)
+
+const (
+	// SchemaVersion of the event data, increased on every breaking change of Change or Product
+	SchemaVersion = "1"
+	ContentType   = "application/json"
+
+	specVersion = "1.0"
+	typePrefix  = "catalog."
+
+	routingKeyPrefix = "product."
+	eventPrefix      = "product_"
+	unknownCategory  = "unknown"
+)
+
+// Envelope is a CloudEvents 1.0 event in the structured JSON format, see https://cloudevents.io
+type Envelope struct {
+	SpecVersion string `json:"specversion"`
+	// id of the outbox event, the same on every redelivery
+	Id string `json:"id"`
+	// the publishing service, e.g. catalog
+	Source string `json:"source"`
+	// e.g. catalog.product_price_updated
+	Type string `json:"type"`
+	// id of the product
+	Subject         string    `json:"subject"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	// extension attribute telling the versions of Data apart
+	SchemaVersion string  `json:"schemaversion"`
+	Data          *Change `json:"data"`
+}
+
+// Change is the data of every product event
+type Change struct {
+	Id string `json:"id"`
+	// null on product_created
+	Before *Product `json:"before"`
+	// null on product_deleted
+	After *Product `json:"after"`
+}
+
+type Product struct {
+	Id       string  `json:"id"`
+	Name     string  `json:"name"`
+	Brand    string  `json:"brand"`
+	Price    float32 `json:"price"`
+	Category string  `json:"category"`
+	Image    string  `json:"image"`
+	Rating   Rating  `json:"rating"`
+}
+
+type Rating struct {
+	// out of 5 (e.g. 3.9)
+	Stars float32 `json:"stars"`
+	// number of customers who reviewed the product
+	Customers int `json:"customers"`
+}
+
+// EventType returns the CloudEvents type of an event type, e.g. catalog.product_created
+func EventType(typ string) string {
+	return typePrefix + typ
+}
+
+// RoutingKey of an event on a topic exchange, product.<category>.<event>, e.g. product.phones.price_updated.
+// The category is the one after the change, or before it on delete, and unknown for an event without
+// a snapshot, e.g. a bulk delete recorded by an earlier version.
+func RoutingKey(e *model.Event) string {
+	category := ""
+	switch {
+	case e.After != nil:
+		category = e.After.Category
+	case e.Before != nil:
+		category = e.Before.Category
+	}
+	if category == "" {
+		category = unknownCategory
+	}
+
+	// a dot would split the category into two words
+	category = strings.Replace(category, ".", "_", -1)
+
+	return routingKeyPrefix + category + "." + strings.TrimPrefix(e.Type, eventPrefix)
+}
+
+func NewEnvelope(source string, e *model.Event) *Envelope {
+	return &Envelope{
+		SpecVersion:     specVersion,
+		Id:              e.Id,
+		Source:          source,
+		Type:            EventType(e.Type),
+		Subject:         e.ProductId,
+		Time:            e.CreatedAt.UTC(),
+		DataContentType: ContentType,
+		SchemaVersion:   SchemaVersion,
+		Data: &Change{
+			Id:     e.ProductId,
+			Before: newProduct(e.Before),
+			After:  newProduct(e.After),
+		},
+	}
+}
+
+func newProduct(p *model.Product) *Product {
+	if p == nil {
+		return nil
+	}
+	return &Product{
+		Id:       p.Id,
+		Name:     p.Name,
+		Brand:    p.Brand,
+		Price:    p.Price,
+		Category: p.Category,
+		Image:    p.Image,
+		Rating:   Rating{Stars: p.Stars, Customers: p.Customers},
+	}
+}
@@ -0,0 +1,81 @@
+package emitter
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/pejovski/catalog/model"
+)
+
+func TestNewEnvelope(t *testing.T) {
+	e := &model.Event{
+		Id:        "1uSBTjlLVBEZVCvBJxvkYDcFVUz",
+		Type:      model.EventProductPriceUpdated,
+		ProductId: "111",
+		Before:    &model.Product{Id: "111", Name: "Galaxy", Price: 800},
+		After:     &model.Product{Id: "111", Name: "Galaxy", Price: 750},
+		CreatedAt: time.Date(2019, 10, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60)),
+	}
+
+	b, err := json.Marshal(NewEnvelope("catalog", e))
+	if err != nil {
+		t.Fatalf("Failed to marshal envelope: %s", err)
+	}
+
+	var env map[string]interface{}
+	_ = json.Unmarshal(b, &env)
+
+	expected := map[string]interface{}{
+		"specversion":     "1.0",
+		"id":              e.Id,
+		"source":          "catalog",
+		"type":            "catalog.product_price_updated",
+		"subject":         "111",
+		"time":            "2019-10-01T10:00:00Z",
+		"datacontenttype": "application/json",
+		"schemaversion":   "1",
+	}
+	for k, v := range expected {
+		if env[k] != v {
+			t.Errorf("Expected %s to be %v, got %v", k, v, env[k])
+		}
+	}
+
+	data := env["data"].(map[string]interface{})
+	if data["before"].(map[string]interface{})["price"] != 800.0 || data["after"].(map[string]interface{})["price"] != 750.0 {
+		t.Errorf("Expected the old and the new price, got %v", data)
+	}
+}
+
+func TestNewEnvelopeDeleted(t *testing.T) {
+	e := &model.Event{Id: "1", Type: model.EventProductDeleted, ProductId: "111"}
+
+	b, _ := json.Marshal(NewEnvelope("catalog", e).Data)
+
+	if string(b) != `{"id":"111","before":null,"after":null}` {
+		t.Errorf("Expected null snapshots, got %s", b)
+	}
+}
+
+func TestRoutingKey(t *testing.T) {
+	phones := &model.Product{Id: "111", Category: "phones"}
+
+	tests := []struct {
+		event *model.Event
+		key   string
+	}{
+		{&model.Event{Type: model.EventProductCreated, After: phones}, "product.phones.created"},
+		{&model.Event{Type: model.EventProductPriceUpdated, Before: &model.Product{Category: "laptops"}, After: phones}, "product.phones.price_updated"},
+		{&model.Event{Type: model.EventProductDeleted, Before: phones}, "product.phones.deleted"},
+		// recorded without a snapshot
+		{&model.Event{Type: model.EventProductDeleted}, "product.unknown.deleted"},
+		{&model.Event{Type: model.EventProductUpdated, After: &model.Product{Category: "smart.watches"}}, "product.smart_watches.updated"},
+	}
+
+	for _, tt := range tests {
+		if key := RoutingKey(tt.event); key != tt.key {
+			t.Errorf("Expected routing key %s, got %s", tt.key, key)
+		}
+	}
+}
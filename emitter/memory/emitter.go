@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"encoding/json"
+
+	"github.com/sirupsen/logrus"
+
+	emit "github.com/pejovski/catalog/emitter"
+	"github.com/pejovski/catalog/model"
+	"github.com/pejovski/catalog/pkg/bus"
+)
+
+const (
+	exProductCreated      = "product_created"
+	exProductUpdated      = "product_updated"
+	exProductDeleted      = "product_deleted"
+	exProductPriceUpdated = "product_price_updated"
+
+	exRatingUpdated = "rating_updated"
+)
+
+// ratingUpdated is the message of the reviewing service, see the amqp handler
+type ratingUpdated struct {
+	ProductId string `json:"product_id"`
+}
+
+// emitter publishes the same envelopes as the amqp emitter onto an in-process bus
+type emitter struct {
+	bus    bus.Bus
+	source string
+}
+
+func NewEmitter(b bus.Bus, source string) emit.Emitter {
+	return emitter{bus: b, source: source}
+}
+
+// ProductCreated also stands in for the reviewing service, which is not running in-process,
+// by publishing rating_updated for the new product so the stubbed rating is received like in production
+func (e emitter) ProductCreated(ev *model.Event) error {
+	if err := e.publish(exProductCreated, ev); err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(ratingUpdated{ProductId: ev.ProductId})
+	if err != nil {
+		logrus.Errorf("Failed to json marshal rating of product %s; Error: %s", ev.ProductId, err)
+		return err
+	}
+
+	e.bus.Publish(exRatingUpdated, b)
+
+	return nil
+}
+
+func (e emitter) ProductUpdated(ev *model.Event) error {
+	return e.publish(exProductUpdated, ev)
+}
+
+func (e emitter) ProductDeleted(ev *model.Event) error {
+	return e.publish(exProductDeleted, ev)
+}
+
+func (e emitter) ProductPriceUpdated(ev *model.Event) error {
+	return e.publish(exProductPriceUpdated, ev)
+}
+
+func (e emitter) publish(ex string, ev *model.Event) error {
+	b, err := json.Marshal(emit.NewEnvelope(e.source, ev))
+	if err != nil {
+		logrus.Errorf("Failed to json marshal event %s of product %s; Error: %s", ev.Id, ev.ProductId, err)
+		return err
+	}
+
+	e.bus.Publish(ex, b)
+
+	logrus.Infof("Event %s for product %s published in-process. Body: %s", ex, ev.ProductId, string(b))
+
+	return nil
+}
@@ -1,7 +1,94 @@
 package error
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
 
+// kinds of errors, check them with errors.Is since they are usually wrapped in an *Error
 var (
 	ErrNotFound = errors.New("not found")
+	// ErrConflict is returned when a write expects a version other than the current one
+	ErrConflict   = errors.New("version conflict")
+	ErrValidation = errors.New("validation failed")
+	// ErrInvalid is returned when the fields of an input break the domain rules, the cause is a FieldErrors
+	ErrInvalid     = errors.New("invalid fields")
+	ErrUnavailable = errors.New("unavailable")
+	ErrTimeout     = errors.New("timeout")
+	// ErrInternal is returned when an upstream rejected a call because of a bug of the catalog, e.g. a malformed query
+	ErrInternal = errors.New("internal error")
+)
+
+var (
+	ErrInvalidCursor = New(ErrValidation, "invalid cursor")
 )
+
+// Error is an error of a kind with the details of the failed call
+type Error struct {
+	// one of ErrNotFound, ErrConflict, ErrValidation, ErrInvalid, ErrUnavailable, ErrTimeout or ErrInternal
+	Kind error
+	// status code of the upstream response (e.g. elasticsearch), zero when there was no response
+	Status int
+	// reason given by the upstream or a message for the client
+	Reason string
+	// cause, if any
+	Err error
+}
+
+// New returns an error of the kind with a reason
+func New(kind error, reason string) *Error {
+	return &Error{Kind: kind, Reason: reason}
+}
+
+// Wrap returns an error of the kind caused by err
+func Wrap(kind error, err error) *Error {
+	return &Error{Kind: kind, Err: err}
+}
+
+func (e *Error) Error() string {
+	msg := e.Kind.Error()
+	if e.Reason != "" {
+		msg = fmt.Sprintf("%s: %s", msg, e.Reason)
+	}
+	if e.Status != 0 {
+		msg = fmt.Sprintf("%s (status code: %d)", msg, e.Status)
+	}
+	if e.Err != nil {
+		msg = fmt.Sprintf("%s: %s", msg, e.Err)
+	}
+	return msg
+}
+
+// Is makes errors.Is(err, ErrNotFound) true for every not found *Error
+func (e *Error) Is(target error) bool {
+	return e.Kind == target
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// FieldError tells why the value of a field was rejected
+type FieldError struct {
+	Field string
+	// machine readable, e.g. required
+	Code    string
+	Message string
+}
+
+// FieldErrors lists every rejected field of an input
+type FieldErrors []FieldError
+
+func (fe FieldErrors) Error() string {
+	msgs := make([]string, len(fe))
+	for i, e := range fe {
+		msgs[i] = fmt.Sprintf("%s: %s", e.Field, e.Message)
+	}
+	return strings.Join(msgs, "; ")
+}
+
+// Invalid returns an ErrInvalid error caused by the field errors
+func Invalid(fe FieldErrors) *Error {
+	return Wrap(ErrInvalid, fe)
+}
@@ -0,0 +1,29 @@
+package error
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestErrorIs(t *testing.T) {
+
+	err := fmt.Errorf("get product: %w", &Error{Kind: ErrNotFound, Status: 404, Reason: "document missing"})
+
+	if !errors.Is(err, ErrNotFound) {
+		t.Error("Expected wrapped error to be not found")
+	}
+
+	if errors.Is(err, ErrConflict) {
+		t.Error("Expected wrapped error not to be a conflict")
+	}
+
+	var e *Error
+	if !errors.As(err, &e) || e.Status != 404 {
+		t.Error("Expected the status to be kept")
+	}
+
+	if !errors.Is(ErrInvalidCursor, ErrValidation) {
+		t.Error("Expected invalid cursor to be a validation error")
+	}
+}
@@ -1,33 +1,12 @@
 package factory
 
 import (
-	"github.com/sirupsen/logrus"
-	"github.com/streadway/amqp"
-)
-
-func CreateAmqpChannel(url string) *amqp.Channel {
-	conn, err := amqp.Dial(url)
-	if err != nil {
-		logrus.Fatalf("%s: %s", "Failed to connect to RabbitMQ", err)
-	}
-
-	ch, err := conn.Channel()
-	if err != nil {
-		logrus.Fatalf("%s: %s", "Failed to open a channel", err)
-	}
-
-	chErr := make(chan *amqp.Error)
+	"context"
 
-	go func() {
-		x, ok := <-chErr
-		if !ok {
-			return
-		}
-		logrus.Fatalf("RabbitMQ channel closed. Reason: %s, Err: %s", x.Reason, x.Error())
-	}()
-
-	ch.NotifyClose(chErr)
-
-	return ch
+	"github.com/pejovski/catalog/pkg/rabbitmq"
+)
 
+// CreateAmqpConnection returns right away, the connection is established and kept open in the background
+func CreateAmqpConnection(ctx context.Context, url string) rabbitmq.Connection {
+	return rabbitmq.Dial(ctx, url)
 }
@@ -0,0 +1,30 @@
+package reviewing
+
+import (
+	"fmt"
+
+	myerr "github.com/pejovski/catalog/error"
+)
+
+var (
+	// ErrRatingNotFound is returned when the reviewing api has no rating for the product
+	ErrRatingNotFound = myerr.New(myerr.ErrNotFound, "rating not found")
+	// ErrUnavailable is returned when the reviewing api responds with 5xx or can not be reached
+	ErrUnavailable = myerr.New(myerr.ErrUnavailable, "reviewing api")
+	// ErrCircuitOpen is returned without calling the reviewing api while it is considered down
+	ErrCircuitOpen = myerr.New(myerr.ErrUnavailable, "reviewing api circuit breaker is open")
+)
+
+// StatusError keeps the status code of an unsuccessful reviewing api response
+type StatusError struct {
+	StatusCode int
+	Err        error
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%s (status code: %d)", e.Err, e.StatusCode)
+}
+
+func (e *StatusError) Unwrap() error {
+	return e.Err
+}
@@ -1,26 +1,124 @@
 package reviewing
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
 	"github.com/hashicorp/go-retryablehttp"
+	"github.com/sirupsen/logrus"
+
 	"github.com/pejovski/catalog/model"
 )
 
+const (
+	// DefaultTimeout bounds a rating call including retries
+	DefaultTimeout = 3 * time.Second
+
+	breakerThreshold = 5
+	breakerCooldown  = 30 * time.Second
+)
+
 type Gateway interface {
-	Rating(productId string) (*model.Rating, error)
+	Rating(ctx context.Context, productId string) (*model.Rating, error)
 }
 
 type gateway struct {
-	client *retryablehttp.Client
-	host   string
+	client  *retryablehttp.Client
+	host    string
+	timeout time.Duration
+	breaker *breaker
 }
 
-func NewGateway(c *retryablehttp.Client, host string) Gateway {
-	return gateway{client: c, host: host}
+// NewGateway calls the reviewing api with a copy of the client, the client itself is left as it is
+func NewGateway(c *retryablehttp.Client, host string, timeout time.Duration) Gateway {
+	client := &retryablehttp.Client{
+		HTTPClient:      c.HTTPClient,
+		Logger:          c.Logger,
+		RetryWaitMin:    c.RetryWaitMin,
+		RetryWaitMax:    c.RetryWaitMax,
+		RetryMax:        c.RetryMax,
+		RequestLogHook:  c.RequestLogHook,
+		ResponseLogHook: c.ResponseLogHook,
+		CheckRetry:      c.CheckRetry,
+		Backoff:         c.Backoff,
+		ErrorHandler:    c.ErrorHandler,
+	}
+	// keep the last response when retries are exhausted so 5xx can be told apart from 404
+	if client.ErrorHandler == nil {
+		client.ErrorHandler = retryablehttp.PassthroughErrorHandler
+	}
+
+	return gateway{
+		client:  client,
+		host:    host,
+		timeout: timeout,
+		breaker: newBreaker(breakerThreshold, breakerCooldown),
+	}
 }
 
-func (g gateway) Rating(productId string) (*model.Rating, error) {
+func (g gateway) Rating(ctx context.Context, productId string) (*model.Rating, error) {
+	if !g.breaker.allow() {
+		return nil, ErrCircuitOpen
+	}
+
+	r, err := g.rating(ctx, productId)
+	// a call given up by the caller says nothing about the reviewing api
+	if ctx.Err() != nil {
+		g.breaker.abort()
+		return nil, ctx.Err()
+	}
+	if errors.Is(err, ErrUnavailable) {
+		g.breaker.failure()
+		return nil, err
+	}
+	g.breaker.success()
+
+	if err != nil {
+		return nil, err
+	}
+
+	return g.mapRatingToDomainRating(*r), nil
+}
+
+func (g gateway) rating(ctx context.Context, productId string) (*Rating, error) {
+	ctx, cancel := context.WithTimeout(ctx, g.timeout)
+	defer cancel()
+
+	req, err := retryablehttp.NewRequest(http.MethodGet, fmt.Sprintf("%s/products/%s/rating", g.host, productId), nil)
+	if err != nil {
+		logrus.Errorf("Failed to create rating request for product %s", productId)
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	res, err := g.client.Do(req.WithContext(ctx))
+	if err != nil {
+		logrus.Errorf("Failed to get rating for product %s. Error: %s", productId, err)
+		return nil, fmt.Errorf("%w: %s", ErrUnavailable, err)
+	}
+	defer res.Body.Close()
+
+	switch {
+	case res.StatusCode == http.StatusOK:
+	case res.StatusCode == http.StatusNotFound:
+		return nil, ErrRatingNotFound
+	case res.StatusCode >= http.StatusInternalServerError:
+		logrus.Errorf("Error in the rating response for product %s. Status code: %d", productId, res.StatusCode)
+		return nil, &StatusError{StatusCode: res.StatusCode, Err: ErrUnavailable}
+	default:
+		logrus.Errorf("Unexpected rating response for product %s. Status code: %d", productId, res.StatusCode)
+		return nil, &StatusError{StatusCode: res.StatusCode, Err: errors.New("unexpected response")}
+	}
+
+	var r Rating
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		logrus.Errorf("Failed to decode rating for product %s", productId)
+		return nil, err
+	}
 
-	// ToDo
-	return nil, errors.New("method not implemented yet")
+	return &r, nil
 }
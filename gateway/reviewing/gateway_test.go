@@ -0,0 +1,160 @@
+package reviewing
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/go-retryablehttp"
+)
+
+func newTestGateway(url string) gateway {
+	c := retryablehttp.NewClient()
+	c.RetryMax = 0
+	c.Logger = nil
+
+	g := NewGateway(c, url, 100*time.Millisecond).(gateway)
+	g.breaker = newBreaker(2, time.Minute)
+
+	return g
+}
+
+func TestRating(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/products/111/rating" {
+			t.Errorf("Unexpected path %s", r.URL.Path)
+		}
+		_, _ = w.Write([]byte(`{"rating": 3.9, "customers": 12}`))
+	}))
+	defer srv.Close()
+
+	r, err := newTestGateway(srv.URL).Rating(context.Background(), "111")
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if r.Stars != 3.9 || r.Customers != 12 {
+		t.Errorf("Expected rating 3.9 from 12 customers, got %v from %d", r.Stars, r.Customers)
+	}
+}
+
+func TestRatingNotFound(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	defer srv.Close()
+
+	g := newTestGateway(srv.URL)
+
+	for i := 0; i < 3; i++ {
+		if _, err := g.Rating(context.Background(), "111"); err != ErrRatingNotFound {
+			t.Fatalf("Expected ErrRatingNotFound, got %v", err)
+		}
+	}
+}
+
+func TestRatingUnavailable(t *testing.T) {
+	calls := 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer srv.Close()
+
+	g := newTestGateway(srv.URL)
+
+	_, err := g.Rating(context.Background(), "111")
+	var se *StatusError
+	if !errors.As(err, &se) || se.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("Expected StatusError with 503, got %v", err)
+	}
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable, got %v", err)
+	}
+
+	// second failure opens the breaker, the third call must not reach the server
+	_, _ = g.Rating(context.Background(), "111")
+	if _, err = g.Rating(context.Background(), "111"); err != ErrCircuitOpen {
+		t.Errorf("Expected ErrCircuitOpen, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("Expected 2 calls to reviewing api, got %d", calls)
+	}
+}
+
+func TestRatingTimeout(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(300 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	if _, err := newTestGateway(srv.URL).Rating(context.Background(), "111"); !errors.Is(err, ErrUnavailable) {
+		t.Errorf("Expected ErrUnavailable on timeout, got %v", err)
+	}
+}
+
+func TestBreakerHalfOpen(t *testing.T) {
+	b := newBreaker(1, 10*time.Millisecond)
+
+	b.failure()
+	if b.allow() {
+		t.Fatal("Expected open breaker to reject calls")
+	}
+
+	time.Sleep(20 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("Expected a trial call after cooldown")
+	}
+	if b.allow() {
+		t.Fatal("Expected only one trial call while half open")
+	}
+
+	b.success()
+	if !b.allow() {
+		t.Error("Expected closed breaker after successful trial")
+	}
+}
+
+func TestRatingCanceled(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		time.Sleep(50 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	c := retryablehttp.NewClient()
+	c.RetryMax = 0
+	c.Logger = nil
+
+	g := NewGateway(c, srv.URL, time.Second).(gateway)
+	g.breaker = newBreaker(1, time.Minute)
+
+	if c.ErrorHandler != nil {
+		t.Error("Expected the client of the caller to be left as it is")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := g.Rating(ctx, "111"); err != context.Canceled {
+		t.Fatalf("Expected context.Canceled, got %v", err)
+	}
+	if !g.breaker.allow() {
+		t.Error("Expected a canceled call not to open the breaker")
+	}
+}
+
+func TestBreakerAbort(t *testing.T) {
+	b := newBreaker(1, 10*time.Millisecond)
+
+	b.failure()
+	time.Sleep(20 * time.Millisecond)
+	if !b.allow() {
+		t.Fatal("Expected a trial call after cooldown")
+	}
+
+	b.abort()
+	if !b.allow() {
+		t.Error("Expected another trial call once the first one was aborted")
+	}
+}
@@ -0,0 +1,28 @@
+package reviewing
+
+import (
+	"context"
+	"hash/fnv"
+
+	"github.com/pejovski/catalog/model"
+)
+
+type stubGateway struct{}
+
+// NewStubGateway returns a gateway that makes up a rating instead of calling the reviewing API,
+// the same product always gets the same rating
+func NewStubGateway() Gateway {
+	return stubGateway{}
+}
+
+func (g stubGateway) Rating(ctx context.Context, productId string) (*model.Rating, error) {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(productId))
+	n := h.Sum32()
+
+	// stars between 3.0 and 5.0 with one decimal
+	return &model.Rating{
+		Stars:     3 + float32(n%21)/10,
+		Customers: int(n % 500),
+	}, nil
+}
@@ -1,59 +1,297 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
 	"github.com/pejovski/catalog/pkg/signals"
+	"github.com/pejovski/catalog/repository"
 	"github.com/pejovski/catalog/repository/es"
+	"github.com/pejovski/catalog/repository/memory"
 	"github.com/pejovski/catalog/server/api"
 	"os"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/hashicorp/go-retryablehttp"
 	_ "github.com/joho/godotenv/autoload"
 	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
 
 	"github.com/pejovski/catalog/controller"
+	emit "github.com/pejovski/catalog/emitter"
 	amqpEmitter "github.com/pejovski/catalog/emitter/amqp"
+	memoryEmitter "github.com/pejovski/catalog/emitter/memory"
 	"github.com/pejovski/catalog/factory"
 	"github.com/pejovski/catalog/gateway/reviewing"
+	"github.com/pejovski/catalog/pkg/bus"
+	"github.com/pejovski/catalog/pkg/rabbitmq"
+	recv "github.com/pejovski/catalog/receiver"
 	amqpReceiver "github.com/pejovski/catalog/receiver/amqp"
+	memoryReceiver "github.com/pejovski/catalog/receiver/memory"
+	"github.com/pejovski/catalog/relay"
 )
 
 const (
 	shutdownDuration = 3 * time.Second
+
+	defaultDedupTTL = 24 * time.Hour
+	defaultDedupMax = 100000
+
+	defaultTopologyFile = "topology.json"
 )
 
 func main() {
-	esClient := factory.CreateESClient(fmt.Sprintf(
-		"http://%s:%s",
-		os.Getenv("ES_HOST"),
-		os.Getenv("ES_PORT"),
-	))
-	amqpCh := factory.CreateAmqpChannel(fmt.Sprintf(
+	standalone := flag.Bool("standalone", os.Getenv("STANDALONE") == "true",
+		"run without elasticsearch, rabbitmq and the reviewing api")
+	flag.Parse()
+
+	if args := flag.Args(); len(args) > 0 {
+		if len(args) == 2 && args[0] == "topology" && args[1] == "verify" {
+			os.Exit(verifyTopology())
+		}
+		logrus.Fatalf("Unknown command %s, usage: %s [-standalone] [topology verify]", strings.Join(args, " "), os.Args[0])
+	}
+
+	// source of the published events
+	source := os.Getenv("APP_NAME")
+	if source == "" {
+		source = "catalog"
+	}
+
+	var (
+		emitter           emit.Emitter
+		catalogRepository repository.Repository
+		outbox            repository.Outbox
+		reviewingGateway  reviewing.Gateway
+		newReceiver       func(h amqpReceiver.Handler) recv.Receiver
+		checks            = map[string]api.HealthCheck{}
+		deadLetters       amqpReceiver.DeadLetters
+		closeAmqp         = func() {}
+	)
+
+	ctx := signals.Context()
+
+	if *standalone {
+		logrus.Warnln("Running standalone: products are kept in memory, events stay in-process and ratings are made up")
+
+		eventBus := bus.New()
+		emitter = memoryEmitter.NewEmitter(eventBus, source)
+		catalogRepository, outbox = memory.NewRepository(), memory.NewOutbox()
+		reviewingGateway = reviewing.NewStubGateway()
+		newReceiver = func(h amqpReceiver.Handler) recv.Receiver {
+			return memoryReceiver.NewReceiver(eventBus, h)
+		}
+	} else {
+		topology := loadTopology()
+
+		// the connection outlives the context, the drained messages are acked before it is closed
+		var amqpCtx context.Context
+		amqpCtx, closeAmqp = context.WithCancel(context.Background())
+
+		// the api keeps serving while rabbitmq is down, events wait in the outbox until it is back
+		amqpConn := factory.CreateAmqpConnection(amqpCtx, amqpURL())
+
+		checks["rabbitmq"] = func() (string, bool) {
+			state := amqpConn.State()
+			return string(state), state == rabbitmq.StateConnected
+		}
+
+		emitter = amqpEmitter.NewEmitter(amqpConn, topology, source, durationEnv("RABBITMQ_CONFIRM_TIMEOUT", amqpEmitter.DefaultConfirmTimeout))
+		var dedup repository.Dedup
+		catalogRepository, outbox, dedup = createRepository()
+		reviewingGateway = reviewing.NewGateway(
+			retryablehttp.NewClient(),
+			os.Getenv("REVIEWING_API_HOST"),
+			durationEnv("REVIEWING_API_TIMEOUT", reviewing.DefaultTimeout),
+		)
+		deadLetters = amqpReceiver.NewDeadLetters(amqpConn, topology)
+		newReceiver = func(h amqpReceiver.Handler) recv.Receiver {
+			return amqpReceiver.NewReceiver(
+				amqpConn,
+				topology,
+				h,
+				retryPolicy(),
+				intEnv("CONSUMER_WORKERS", amqpReceiver.DefaultWorkers),
+				durationEnv("CONSUMER_COALESCE_WINDOW", amqpReceiver.DefaultCoalesceWindow),
+				dedup,
+			)
+		}
+	}
+
+	// comma separated, e.g. phones,laptops
+	categories := os.Getenv("CATEGORIES")
+	if categories == "" {
+		logrus.Warnln("CATEGORIES is empty, products of any category are accepted")
+	}
+	validator := controller.NewValidator(strings.Split(categories, ","))
+
+	catalogController := controller.New(catalogRepository, outbox, reviewingGateway, validator)
+
+	outboxRelay := relay.NewRelay(
+		outbox,
+		emitter,
+		durationEnv("OUTBOX_RELAY_INTERVAL", relay.DefaultInterval),
+		durationEnv("OUTBOX_GRACE", relay.DefaultGrace),
+	)
+	go outboxRelay.Run(ctx)
+
+	amqpHandler := amqpReceiver.NewHandler(catalogController)
+	receiver := newReceiver(amqpHandler)
+	// receive messages in goroutines
+	receiver.Receive(ctx)
+
+	// the admin endpoints are not authenticated, they are served only on an address of their own
+	adminAddr := os.Getenv("ADMIN_ADDR")
+	if deadLetters != nil && adminAddr == "" {
+		logrus.Warnln("ADMIN_ADDR is empty, the admin endpoints are not served")
+	}
+
+	serverAPI := api.NewServer(catalogController, checks, deadLetters, adminAddr)
+	serverAPI.Run(ctx)
+
+	logrus.Infof("allowing %s for graceful shutdown to complete", shutdownDuration)
+	select {
+	case <-receiver.Drained():
+	case <-time.After(shutdownDuration):
+		logrus.Warnln("Messages still in-flight are redelivered once their connection is closed")
+	}
+	closeAmqp()
+}
+
+func amqpURL() string {
+	return fmt.Sprintf(
 		"amqp://%s:%s@%s:%s/%s",
 		os.Getenv("RABBITMQ_USER"),
 		os.Getenv("RABBITMQ_PASSWORD"),
 		os.Getenv("RABBITMQ_HOST"),
 		os.Getenv("RABBITMQ_PORT"),
 		os.Getenv("RABBITMQ_VHOST"),
+	)
+}
+
+func retryPolicy() amqpReceiver.RetryPolicy {
+	return amqpReceiver.RetryPolicy{
+		MaxAttempts: intEnv("CONSUMER_MAX_ATTEMPTS", amqpReceiver.DefaultRetryPolicy.MaxAttempts),
+		Delay:       durationEnv("CONSUMER_RETRY_DELAY", amqpReceiver.DefaultRetryPolicy.Delay),
+	}
+}
+
+// loadTopology reads the topology from TOPOLOGY_FILE, topology.json by default,
+// the service does not start with a topology missing any of the published or consumed messages
+func loadTopology() *rabbitmq.Topology {
+	path := os.Getenv("TOPOLOGY_FILE")
+	if path == "" {
+		path = defaultTopologyFile
+	}
+
+	t, err := rabbitmq.LoadTopology(path)
+	if err != nil {
+		logrus.Fatalf("Failed to load rabbitmq topology: %s", err)
+	}
+	if err := t.Validate(amqpEmitter.Messages, amqpReceiver.Messages); err != nil {
+		logrus.Fatalf("Invalid rabbitmq topology %s: %s", path, err)
+	}
+
+	return t
+}
+
+// verifyTopology checks that the broker has the topology, including the retry and dead-letter queues,
+// and returns the exit code
+func verifyTopology() int {
+	t := amqpReceiver.Topology(loadTopology(), retryPolicy())
+
+	conn, err := amqp.Dial(amqpURL())
+	if err != nil {
+		logrus.Errorf("Failed to connect to RabbitMQ; Error: %s", err)
+		return 1
+	}
+	defer conn.Close()
+
+	problems := t.Verify(conn)
+	for _, p := range problems {
+		fmt.Println(p)
+	}
+	if len(problems) > 0 {
+		return 1
+	}
+
+	fmt.Printf("%d exchanges and %d queues match the topology\n", len(t.Exchanges), len(t.Queues))
+	return 0
+}
+
+// createRepository returns the repository, the outbox and the dedup store selected by REPOSITORY,
+// elasticsearch by default. DEDUP=memory keeps the handled messages in memory with any repository.
+func createRepository() (repository.Repository, repository.Outbox, repository.Dedup) {
+	dedupTTL := durationEnv("DEDUP_TTL", defaultDedupTTL)
+	memoryDedup := os.Getenv("DEDUP") == "memory"
+
+	if os.Getenv("REPOSITORY") == "memory" {
+		logrus.Warnln("Using in-memory repository, products and unsent events are lost on shutdown")
+		return memory.NewRepository(), memory.NewOutbox(), memory.NewDedup(dedupTTL, intEnv("DEDUP_MAX", defaultDedupMax))
+	}
+
+	esClient := factory.CreateESClient(fmt.Sprintf(
+		"http://%s:%s",
+		os.Getenv("ES_HOST"),
+		os.Getenv("ES_PORT"),
 	))
+	if err := es.SetupIndex(esClient); err != nil {
+		logrus.Fatalf("Failed to set up elasticsearch index: %s", err)
+	}
+	if err := es.SetupOutbox(esClient); err != nil {
+		logrus.Fatalf("Failed to set up elasticsearch outbox: %s", err)
+	}
+	if !memoryDedup {
+		if err := es.SetupDedup(esClient); err != nil {
+			logrus.Fatalf("Failed to set up elasticsearch dedup: %s", err)
+		}
+	}
 
-	emitter := amqpEmitter.NewEmitter(amqpCh)
-	catalogRepository := es.NewRepository(esClient)
-	reviewingGateway := reviewing.NewGateway(retryablehttp.NewClient(), os.Getenv("REVIEWING_API_HOST"))
+	timeouts := es.Timeouts{
+		Read:   durationEnv("ES_READ_TIMEOUT", es.DefaultTimeouts.Read),
+		Write:  durationEnv("ES_WRITE_TIMEOUT", es.DefaultTimeouts.Write),
+		Search: durationEnv("ES_SEARCH_TIMEOUT", es.DefaultTimeouts.Search),
+		Bulk:   durationEnv("ES_BULK_TIMEOUT", es.DefaultTimeouts.Bulk),
+	}
 
-	catalogController := controller.New(catalogRepository, emitter, reviewingGateway)
+	dedup := es.NewDedup(esClient, timeouts, dedupTTL)
+	if memoryDedup {
+		dedup = memory.NewDedup(dedupTTL, intEnv("DEDUP_MAX", defaultDedupMax))
+	}
 
-	amqpHandler := amqpReceiver.NewHandler(catalogController)
-	receiver := amqpReceiver.NewReceiver(amqpCh, amqpHandler)
-	// receive messages in goroutines
-	receiver.Receive()
+	return es.NewRepository(esClient, timeouts), es.NewOutbox(esClient, timeouts), dedup
+}
 
-	ctx := signals.Context()
+// durationEnv parses a duration (e.g. 1500ms) from the environment, def is used when it is missing or invalid
+func durationEnv(key string, def time.Duration) time.Duration {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
 
-	serverAPI := api.NewServer(catalogController)
-	serverAPI.Run(ctx)
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		logrus.Warnf("Invalid duration %s=%s, using %s", key, v, def)
+		return def
+	}
 
-	logrus.Infof("allowing %s for graceful shutdown to complete", shutdownDuration)
-	<-time.After(shutdownDuration)
+	return d
+}
+
+// intEnv parses a positive number from the environment, def is used when it is missing or invalid
+func intEnv(key string, def int) int {
+	v := os.Getenv(key)
+	if v == "" {
+		return def
+	}
+
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 1 {
+		logrus.Warnf("Invalid number %s=%s, using %d", key, v, def)
+		return def
+	}
+
+	return n
 }
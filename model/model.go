@@ -1,5 +1,7 @@
 package model
 
+import "time"
+
 type Product struct {
 	Id       string  `json:"id"`
 	Name     string  `json:"name"`
@@ -8,6 +10,14 @@ type Product struct {
 	Category string  `json:"category"`
 	Image    string  `json:"image"`
 	Rating
+	// set when the product is read, an update with a version fails if the product changed meanwhile
+	Version *Version `json:"-"`
+}
+
+// Version identifies a revision of a product for optimistic concurrency control
+type Version struct {
+	SeqNo       int
+	PrimaryTerm int
 }
 
 type Rating struct {
@@ -16,3 +26,144 @@ type Rating struct {
 	// number of customers who reviewed the product
 	Customers int `json:"customers"`
 }
+
+// SearchHit is a product found by a full-text search
+type SearchHit struct {
+	Product *Product
+	// highlighted fragments per matched field (e.g. name: ["<em>Galaxy</em> S10"])
+	Highlights map[string][]string
+}
+
+// Page selects a slice of a product listing
+type Page struct {
+	Limit int
+	// opaque position returned as NextCursor by the previous page, empty for the first page
+	Cursor string
+}
+
+// ProductPage is a slice of a product listing
+type ProductPage struct {
+	Products []*Product
+	// empty when there are no more products
+	NextCursor string
+	// number of products in the whole listing
+	Total  int
+	Facets *Facets
+}
+
+// Filter narrows a listing down to the selected facet values.
+// Values of the same facet are OR-ed, different facets are AND-ed.
+type Filter struct {
+	Categories []string
+	Brands     []string
+	// keys of PriceRanges
+	Prices []string
+	// keys of RatingRanges
+	Ratings []string
+}
+
+// Facets are the counts of products per facet value, each facet is counted
+// with the selections of all other facets applied but not its own
+type Facets struct {
+	Categories []*Bucket
+	Brands     []*Bucket
+	Prices     []*Bucket
+	Ratings    []*Bucket
+}
+
+type Bucket struct {
+	Key      string
+	Count    int
+	Selected bool
+}
+
+// Range is a named bucket of a numeric facet, From is inclusive, To is exclusive and unbounded when zero
+type Range struct {
+	Key  string
+	From float32
+	To   float32
+}
+
+var PriceRanges = []Range{
+	{Key: "0-50", From: 0, To: 50},
+	{Key: "50-100", From: 50, To: 100},
+	{Key: "100-500", From: 100, To: 500},
+	{Key: "500-1000", From: 500, To: 1000},
+	{Key: "1000-*", From: 1000},
+}
+
+// RatingRanges overlap on purpose, e.g. "4-*" reads as 4 stars & up
+var RatingRanges = []Range{
+	{Key: "4-*", From: 4},
+	{Key: "3-*", From: 3},
+	{Key: "2-*", From: 2},
+	{Key: "1-*", From: 1},
+}
+
+// FindRange returns the range with the key
+func FindRange(rs []Range, key string) (Range, bool) {
+	for _, r := range rs {
+		if r.Key == key {
+			return r, true
+		}
+	}
+	return Range{}, false
+}
+
+// Contains reports whether the value falls into the range
+func (r Range) Contains(v float32) bool {
+	return v >= r.From && (r.To == 0 || v < r.To)
+}
+
+// SearchResult is the outcome of a full-text search
+type SearchResult struct {
+	Hits   []*SearchHit
+	Facets *Facets
+	Total  int
+}
+
+const (
+	OpCreate = "create"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// BulkOperation is a single write of a bulk import
+type BulkOperation struct {
+	// one of OpCreate, OpUpdate, OpDelete
+	Type string
+	// Product.Id identifies the product to update or delete
+	Product *Product
+}
+
+// BulkResult is the outcome of a BulkOperation
+type BulkResult struct {
+	Type string
+	// generated id on create
+	Id string
+	// http status code of the operation
+	Status int
+	// empty on success
+	Error string
+}
+
+// event types, named like the exchanges they are published to
+const (
+	EventProductCreated      = "product_created"
+	EventProductUpdated      = "product_updated"
+	EventProductDeleted      = "product_deleted"
+	EventProductPriceUpdated = "product_price_updated"
+)
+
+// Event is a product change recorded in the outbox alongside the write and published by the relay
+type Event struct {
+	Id        string
+	Type      string
+	ProductId string
+	// snapshots of the product around the change, Before is nil on create and After on delete
+	Before    *Product
+	After     *Product
+	CreatedAt time.Time
+	// failed publishing attempts so far
+	Attempts int
+}
@@ -0,0 +1,50 @@
+package bus
+
+import (
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// subscriberBuffer is the number of messages a subscriber can fall behind before new ones are dropped
+const subscriberBuffer = 1000
+
+// Bus is an in-process fanout message bus standing in for RabbitMQ in standalone mode,
+// every subscriber of an exchange gets its own copy of each message
+type Bus interface {
+	Publish(exchange string, body []byte)
+	Subscribe(exchange string) <-chan []byte
+}
+
+type bus struct {
+	mu          sync.RWMutex
+	subscribers map[string][]chan []byte
+}
+
+func New() Bus {
+	return &bus{subscribers: map[string][]chan []byte{}}
+}
+
+// Publish never blocks, messages without subscribers are discarded like on an unbound fanout exchange
+func (b *bus) Publish(exchange string, body []byte) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for _, ch := range b.subscribers[exchange] {
+		select {
+		case ch <- body:
+		default:
+			logrus.Warnf("Subscriber of exchange %s is full, dropping message %s", exchange, string(body))
+		}
+	}
+}
+
+func (b *bus) Subscribe(exchange string) <-chan []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan []byte, subscriberBuffer)
+	b.subscribers[exchange] = append(b.subscribers[exchange], ch)
+
+	return ch
+}
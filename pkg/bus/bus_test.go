@@ -0,0 +1,34 @@
+package bus
+
+import "testing"
+
+func TestPublishFansOut(t *testing.T) {
+	b := New()
+	first := b.Subscribe("product_updated")
+	second := b.Subscribe("product_updated")
+	other := b.Subscribe("product_deleted")
+
+	b.Publish("product_updated", []byte(`{"id":"1"}`))
+
+	for _, ch := range []<-chan []byte{first, second} {
+		select {
+		case body := <-ch:
+			if string(body) != `{"id":"1"}` {
+				t.Errorf("Unexpected body %s", body)
+			}
+		default:
+			t.Error("Subscriber did not get the message")
+		}
+	}
+
+	select {
+	case body := <-other:
+		t.Errorf("Subscriber of another exchange got %s", body)
+	default:
+	}
+}
+
+func TestPublishWithoutSubscribers(t *testing.T) {
+	// must neither block nor panic
+	New().Publish("product_updated", []byte(`{"id":"1"}`))
+}
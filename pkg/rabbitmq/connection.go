@@ -0,0 +1,184 @@
+package rabbitmq
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+const (
+	minBackoff = 500 * time.Millisecond
+	maxBackoff = 30 * time.Second
+)
+
+// State of the connection, reported by the health check
+type State string
+
+const (
+	StateConnecting   State = "connecting"
+	StateConnected    State = "connected"
+	StateDisconnected State = "disconnected"
+	StateClosed       State = "closed"
+)
+
+// ErrNotConnected is returned by publishers while the connection is down
+var ErrNotConnected = errors.New("not connected to rabbitmq")
+
+// Hook sets up a channel of a new connection, e.g. declares the topology and starts consuming.
+// It owns the channel, which is closed together with the connection.
+type Hook func(ch *amqp.Channel) error
+
+// Connection keeps a connection to RabbitMQ open, reconnecting with exponential backoff whenever it drops.
+// The hooks are run on every connection, so the topology is declared again and consumers are restarted.
+type Connection interface {
+	// OnConnect registers the hook, it is also run right away when connected
+	OnConnect(name string, h Hook)
+	// Channel opens a channel on the current connection, the caller closes it
+	Channel() (*amqp.Channel, error)
+	State() State
+}
+
+type hook struct {
+	name string
+	run  Hook
+}
+
+type connection struct {
+	url string
+
+	mu    sync.Mutex
+	conn  *amqp.Connection
+	state State
+	hooks []hook
+}
+
+// Dial connects in the background and returns right away, the connection is closed when the context is canceled
+func Dial(ctx context.Context, url string) Connection {
+	c := &connection{url: url, state: StateConnecting}
+	go c.run(ctx)
+	return c
+}
+
+func (c *connection) OnConnect(name string, h Hook) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hooks = append(c.hooks, hook{name: name, run: h})
+
+	if c.state != StateConnected {
+		return
+	}
+
+	if err := c.runHook(c.conn, hook{name: name, run: h}); err != nil {
+		logrus.Errorf("Failed to set up %s, reconnecting; Error: %s", name, err)
+		// the reconnect runs every hook including this one
+		_ = c.conn.Close()
+	}
+}
+
+func (c *connection) Channel() (*amqp.Channel, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.state != StateConnected {
+		return nil, ErrNotConnected
+	}
+
+	return c.conn.Channel()
+}
+
+func (c *connection) State() State {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.state
+}
+
+func (c *connection) run(ctx context.Context) {
+	backoff := minBackoff
+
+	for {
+		conn, err := c.connect()
+		if err != nil {
+			c.setState(StateDisconnected)
+			logrus.Errorf("Failed to connect to RabbitMQ, retrying in %s; Error: %s", backoff, err)
+
+			select {
+			case <-ctx.Done():
+				c.setState(StateClosed)
+				return
+			case <-time.After(backoff):
+			}
+
+			if backoff *= 2; backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			continue
+		}
+
+		backoff = minBackoff
+		closed := conn.NotifyClose(make(chan *amqp.Error, 1))
+
+		select {
+		case <-ctx.Done():
+			c.setState(StateClosed)
+			if err := conn.Close(); err != nil {
+				logrus.Errorf("Failed to close RabbitMQ connection; Error: %s", err)
+			}
+			return
+		case err := <-closed:
+			c.setState(StateDisconnected)
+			logrus.Errorf("RabbitMQ connection closed, reconnecting; Error: %v", err)
+		}
+	}
+}
+
+// connect dials and runs the hooks, the connection is returned only when all of them succeeded
+func (c *connection) connect() (*amqp.Connection, error) {
+	conn, err := amqp.Dial(c.url)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, h := range c.hooks {
+		if err := c.runHook(conn, h); err != nil {
+			_ = conn.Close()
+			return nil, err
+		}
+	}
+
+	c.conn, c.state = conn, StateConnected
+	logrus.Infoln("Connected to RabbitMQ")
+
+	return conn, nil
+}
+
+func (c *connection) runHook(conn *amqp.Connection, h hook) error {
+	ch, err := conn.Channel()
+	if err != nil {
+		return err
+	}
+
+	if err := h.run(ch); err != nil {
+		_ = ch.Close()
+		return err
+	}
+
+	logrus.Infof("RabbitMQ %s set up", h.name)
+
+	return nil
+}
+
+func (c *connection) setState(s State) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.state = s
+}
@@ -0,0 +1,258 @@
+package rabbitmq
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// Topology is the declarative definition of the exchanges, queues and bindings a service relies on,
+// together with the exchanges it publishes each message type to and the queues it consumes them from
+type Topology struct {
+	Exchanges []*Exchange `json:"exchanges"`
+	Queues    []*Queue    `json:"queues"`
+	Bindings  []*Binding  `json:"bindings"`
+	// Publish maps a message type, e.g. product_created, to the exchange it is published to
+	Publish map[string]string `json:"publish"`
+	// Topic is a topic exchange every message is published to as well, with a routing key telling its type apart
+	Topic string `json:"topic"`
+	// Consume maps a message type, e.g. rating_updated, to the queue it is consumed from
+	Consume map[string]string `json:"consume"`
+}
+
+type Exchange struct {
+	Name string `json:"name"`
+	// Kind is fanout, direct, topic or headers
+	Kind       string     `json:"kind"`
+	Durable    bool       `json:"durable"`
+	AutoDelete bool       `json:"auto_delete"`
+	Internal   bool       `json:"internal"`
+	Arguments  amqp.Table `json:"arguments"`
+}
+
+type Queue struct {
+	Name       string `json:"name"`
+	Durable    bool   `json:"durable"`
+	AutoDelete bool   `json:"auto_delete"`
+	Exclusive  bool   `json:"exclusive"`
+	// DeadLetterExchange receives the rejected and expired messages, empty is the default exchange
+	DeadLetterExchange *string `json:"dead_letter_exchange"`
+	// DeadLetterRoutingKey replaces the routing key of the dead-lettered messages
+	DeadLetterRoutingKey string `json:"dead_letter_routing_key"`
+	// MessageTTL in milliseconds, 0 keeps the messages until they are consumed
+	MessageTTL int64      `json:"message_ttl"`
+	Arguments  amqp.Table `json:"arguments"`
+}
+
+type Binding struct {
+	Queue      string     `json:"queue"`
+	Exchange   string     `json:"exchange"`
+	RoutingKey string     `json:"routing_key"`
+	Arguments  amqp.Table `json:"arguments"`
+}
+
+// LoadTopology reads the topology from a JSON file
+func LoadTopology(path string) (*Topology, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	dec := json.NewDecoder(f)
+	// integer arguments such as x-message-ttl must not be sent as floats
+	dec.UseNumber()
+	dec.DisallowUnknownFields()
+
+	var t Topology
+	if err := dec.Decode(&t); err != nil {
+		return nil, fmt.Errorf("invalid topology %s: %w", path, err)
+	}
+
+	for _, ex := range t.Exchanges {
+		ex.Arguments = arguments(ex.Arguments)
+	}
+	for _, q := range t.Queues {
+		q.Arguments = arguments(q.Arguments)
+	}
+	for _, b := range t.Bindings {
+		b.Arguments = arguments(b.Arguments)
+	}
+
+	return &t, nil
+}
+
+// arguments converts the JSON numbers to the integers and floats amqp encodes
+func arguments(args amqp.Table) amqp.Table {
+	if args == nil {
+		return nil
+	}
+
+	converted := amqp.Table{}
+	for k, v := range args {
+		n, ok := v.(json.Number)
+		if !ok {
+			converted[k] = v
+			continue
+		}
+		if i, err := n.Int64(); err == nil {
+			converted[k] = i
+			continue
+		}
+		f, _ := n.Float64()
+		converted[k] = f
+	}
+	return converted
+}
+
+// Validate checks the references of the topology and that the message types are mapped
+func (t *Topology) Validate(publish []string, consume []string) error {
+	exchanges, queues := map[string]bool{}, map[string]bool{}
+	topic := false
+	problems := []string{}
+
+	for _, ex := range t.Exchanges {
+		switch ex.Kind {
+		case amqp.ExchangeFanout, amqp.ExchangeDirect, amqp.ExchangeTopic, amqp.ExchangeHeaders:
+		default:
+			problems = append(problems, fmt.Sprintf("exchange %s has unknown kind %q", ex.Name, ex.Kind))
+		}
+		exchanges[ex.Name] = true
+		if ex.Name == t.Topic {
+			topic = ex.Kind == amqp.ExchangeTopic
+		}
+	}
+	if t.Topic != "" && !topic {
+		problems = append(problems, fmt.Sprintf("topic %s is not a declared topic exchange", t.Topic))
+	}
+	for _, q := range t.Queues {
+		queues[q.Name] = true
+	}
+
+	for _, b := range t.Bindings {
+		if !exchanges[b.Exchange] {
+			problems = append(problems, fmt.Sprintf("binding of queue %s to undeclared exchange %s", b.Queue, b.Exchange))
+		}
+		if !queues[b.Queue] {
+			problems = append(problems, fmt.Sprintf("binding of undeclared queue %s to exchange %s", b.Queue, b.Exchange))
+		}
+	}
+
+	for _, typ := range publish {
+		ex, ok := t.Publish[typ]
+		if ok && !exchanges[ex] {
+			problems = append(problems, fmt.Sprintf("%s is published to undeclared exchange %s", typ, ex))
+		}
+		if !ok && t.Topic == "" {
+			problems = append(problems, fmt.Sprintf("%s is not published to any exchange", typ))
+		}
+	}
+	for _, typ := range consume {
+		if q, ok := t.Consume[typ]; !ok || !queues[q] {
+			problems = append(problems, fmt.Sprintf("%s is not consumed from a declared queue", typ))
+		}
+	}
+
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// Declare declares the topology, declaring it again is a no-op as long as it did not change.
+// An entity declared with other properties than it exists with fails with PRECONDITION_FAILED.
+func (t *Topology) Declare(ch *amqp.Channel) error {
+	for _, ex := range t.Exchanges {
+		if err := ch.ExchangeDeclare(ex.Name, ex.Kind, ex.Durable, ex.AutoDelete, ex.Internal, false, ex.Arguments); err != nil {
+			logrus.Errorf("%s %s: %s", "Failed to declare an exchange", ex.Name, err)
+			return err
+		}
+	}
+
+	for _, q := range t.Queues {
+		if _, err := ch.QueueDeclare(q.Name, q.Durable, q.AutoDelete, q.Exclusive, false, q.arguments()); err != nil {
+			logrus.Errorf("%s %s: %s", "Failed to declare a queue", q.Name, err)
+			return err
+		}
+	}
+
+	for _, b := range t.Bindings {
+		if err := ch.QueueBind(b.Queue, b.RoutingKey, b.Exchange, false, b.Arguments); err != nil {
+			logrus.Errorf("Failed to bind queue %s to exchange %s: %s", b.Queue, b.Exchange, err)
+			return err
+		}
+	}
+
+	return nil
+}
+
+// arguments of the declaration, including the dead-lettering and the ttl
+func (q *Queue) arguments() amqp.Table {
+	args := amqp.Table{}
+	for k, v := range q.Arguments {
+		args[k] = v
+	}
+	if q.DeadLetterExchange != nil {
+		args["x-dead-letter-exchange"] = *q.DeadLetterExchange
+	}
+	if q.DeadLetterRoutingKey != "" {
+		args["x-dead-letter-routing-key"] = q.DeadLetterRoutingKey
+	}
+	if q.MessageTTL > 0 {
+		args["x-message-ttl"] = q.MessageTTL
+	}
+	if len(args) == 0 {
+		return nil
+	}
+	return args
+}
+
+// Verify checks that every exchange and queue exists with the declared properties, without creating
+// or changing any of them. Bindings cannot be checked over AMQP and are not verified.
+func (t *Topology) Verify(conn *amqp.Connection) []error {
+	problems := []error{}
+
+	// a failed check closes its channel, so every check gets a channel of its own
+	check := func(name string, declare func(ch *amqp.Channel) error) {
+		ch, err := conn.Channel()
+		if err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", name, err))
+			return
+		}
+		defer ch.Close()
+
+		if err := declare(ch); err != nil {
+			problems = append(problems, fmt.Errorf("%s: %w", name, err))
+		}
+	}
+
+	for _, ex := range t.Exchanges {
+		ex := ex
+		check("exchange "+ex.Name, func(ch *amqp.Channel) error {
+			if err := ch.ExchangeDeclarePassive(ex.Name, ex.Kind, ex.Durable, ex.AutoDelete, ex.Internal, false, ex.Arguments); err != nil {
+				return err
+			}
+			// it exists, declaring it again fails when its properties differ
+			return ch.ExchangeDeclare(ex.Name, ex.Kind, ex.Durable, ex.AutoDelete, ex.Internal, false, ex.Arguments)
+		})
+	}
+
+	for _, q := range t.Queues {
+		q := q
+		check("queue "+q.Name, func(ch *amqp.Channel) error {
+			if _, err := ch.QueueDeclarePassive(q.Name, q.Durable, q.AutoDelete, q.Exclusive, false, q.arguments()); err != nil {
+				return err
+			}
+			_, err := ch.QueueDeclare(q.Name, q.Durable, q.AutoDelete, q.Exclusive, false, q.arguments())
+			return err
+		})
+	}
+
+	return problems
+}
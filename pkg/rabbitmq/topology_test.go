@@ -0,0 +1,114 @@
+package rabbitmq
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestLoadTopology(t *testing.T) {
+	path := writeTopology(t, `{
+		"exchanges": [{"name": "rating_updated", "kind": "fanout", "durable": true}],
+		"queues": [{"name": "rating_updated:catalog", "durable": true, "arguments": {"x-max-length": 1000, "x-queue-mode": "lazy"}}],
+		"bindings": [{"queue": "rating_updated:catalog", "exchange": "rating_updated"}],
+		"consume": {"rating_updated": "rating_updated:catalog"}
+	}`)
+	defer os.Remove(path)
+
+	topology, err := LoadTopology(path)
+	if err != nil {
+		t.Fatalf("Failed to load topology; Error: %s", err)
+	}
+
+	if err := topology.Validate(nil, []string{"rating_updated"}); err != nil {
+		t.Errorf("Expected a valid topology, got %s", err)
+	}
+
+	args := topology.Queues[0].arguments()
+	if _, ok := args["x-max-length"].(int64); !ok {
+		t.Errorf("Expected x-max-length to be an integer, got %T", args["x-max-length"])
+	}
+	if args["x-queue-mode"] != "lazy" {
+		t.Errorf("Expected x-queue-mode lazy, got %v", args["x-queue-mode"])
+	}
+
+	if b := topology.Bindings[0]; b.Queue != "rating_updated:catalog" || b.Exchange != "rating_updated" {
+		t.Errorf("Expected the queue to be bound to rating_updated, got %+v", b)
+	}
+}
+
+func TestLoadTopologyUnknownField(t *testing.T) {
+	path := writeTopology(t, `{"exchanges": [{"name": "rating_updated", "type": "fanout"}]}`)
+	defer os.Remove(path)
+
+	if _, err := LoadTopology(path); err == nil {
+		t.Error("Expected an error for an unknown field")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	topology := &Topology{
+		Exchanges: []*Exchange{{Name: "product_created", Kind: "fanout"}, {Name: "product_deleted", Kind: "broadcast"}},
+		Queues:    []*Queue{{Name: "rating_updated:catalog"}},
+		Bindings:  []*Binding{{Queue: "rating_updated:catalog", Exchange: "rating_updated"}},
+		Publish:   map[string]string{"product_created": "product_created", "product_deleted": "product_removed"},
+	}
+
+	err := topology.Validate([]string{"product_created", "product_deleted"}, []string{"rating_updated"})
+	if err == nil {
+		t.Fatal("Expected an invalid topology")
+	}
+
+	for _, problem := range []string{
+		`exchange product_deleted has unknown kind "broadcast"`,
+		"binding of queue rating_updated:catalog to undeclared exchange rating_updated",
+		"product_deleted is published to undeclared exchange product_removed",
+		"rating_updated is not consumed from a declared queue",
+	} {
+		if !strings.Contains(err.Error(), problem) {
+			t.Errorf("Expected problem %q, got %s", problem, err)
+		}
+	}
+	if strings.Contains(err.Error(), "product_created is not") {
+		t.Errorf("Expected product_created to be valid, got %s", err)
+	}
+}
+
+func TestValidateTopic(t *testing.T) {
+	topology := &Topology{
+		Exchanges: []*Exchange{{Name: "product_created", Kind: "fanout"}, {Name: "catalog.events", Kind: "fanout"}},
+		Publish:   map[string]string{"product_created": "product_created"},
+		Topic:     "catalog.events",
+	}
+
+	// the types missing in publish go only to the topic exchange
+	err := topology.Validate([]string{"product_created", "product_deleted"}, nil)
+	if err == nil || err.Error() != "topic catalog.events is not a declared topic exchange" {
+		t.Errorf("Expected only the kind of the topic exchange to be invalid, got %v", err)
+	}
+
+	topology.Exchanges[1].Kind = "topic"
+	if err := topology.Validate([]string{"product_created", "product_deleted"}, nil); err != nil {
+		t.Errorf("Expected a valid topology, got %s", err)
+	}
+
+	topology.Topic = ""
+	if err := topology.Validate([]string{"product_created", "product_deleted"}, nil); err == nil || !strings.Contains(err.Error(), "product_deleted is not published to any exchange") {
+		t.Errorf("Expected product_deleted to be unpublished, got %v", err)
+	}
+}
+
+func writeTopology(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "topology*.json")
+	if err != nil {
+		t.Fatalf("Failed to create topology file; Error: %s", err)
+	}
+	defer f.Close()
+
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatalf("Failed to write topology file; Error: %s", err)
+	}
+
+	return f.Name()
+}
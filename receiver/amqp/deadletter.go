@@ -0,0 +1,248 @@
+package amqp
+
+import (
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+
+	"github.com/pejovski/catalog/pkg/rabbitmq"
+)
+
+// DeadLetter is a message which ran out of attempts, see retry
+type DeadLetter struct {
+	// Id is the message id, or a hash of the body when the publisher did not set one
+	Id    string
+	Queue string
+	// Exchange the message was originally published to
+	Exchange  string
+	Reason    string
+	Attempts  int
+	Timestamp time.Time
+	Headers   amqp.Table
+	Body      []byte
+}
+
+// DeadLetters manages the dead-letter queues of the queues declared by the receiver
+type DeadLetters interface {
+	// List returns up to limit messages of every dead-letter queue, they stay in the queues
+	List(limit int) ([]*DeadLetter, error)
+	// Replay publishes the messages with the ids, or all messages when ids is nil,
+	// straight to the queue they were consumed from, with their attempts reset
+	Replay(ids []string) (int, error)
+	// Purge drops the messages with the ids, or all messages when ids is nil
+	Purge(ids []string) (int, error)
+}
+
+type deadLetters struct {
+	conn     rabbitmq.Connection
+	topology *rabbitmq.Topology
+}
+
+func NewDeadLetters(conn rabbitmq.Connection, t *rabbitmq.Topology) DeadLetters {
+	return deadLetters{conn: conn, topology: t}
+}
+
+// origins returns the dead-letter queues and the queues their messages were consumed from by their name
+func (dl deadLetters) origins() ([]string, map[string]string) {
+	queues, origins := []string{}, map[string]string{}
+
+	for _, msg := range Messages {
+		queue := dl.topology.Consume[msg]
+		dlq := deadLetterQueue(queue)
+		queues = append(queues, dlq)
+		origins[dlq] = queue
+	}
+
+	return queues, origins
+}
+
+func (dl deadLetters) List(limit int) ([]*DeadLetter, error) {
+	ch, err := dl.conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+	// the messages which were got are requeued in their original position
+	defer ch.Close()
+
+	letters := []*DeadLetter{}
+
+	queues, _ := dl.origins()
+	for _, queue := range queues {
+		for i := 0; i < limit; i++ {
+			d, ok, err := ch.Get(queue, false)
+			if err != nil {
+				logrus.Errorf("Failed to get a message from %s; Error: %s", queue, err)
+				return nil, err
+			}
+			if !ok {
+				break
+			}
+			letters = append(letters, deadLetter(queue, &d))
+		}
+	}
+
+	return letters, nil
+}
+
+func (dl deadLetters) Replay(ids []string) (int, error) {
+	return dl.take(ids, true)
+}
+
+func (dl deadLetters) Purge(ids []string) (int, error) {
+	if ids != nil {
+		return dl.take(ids, false)
+	}
+
+	ch, err := dl.conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	n := 0
+	queues, _ := dl.origins()
+	for _, queue := range queues {
+		purged, err := ch.QueuePurge(queue, false)
+		if err != nil {
+			logrus.Errorf("Failed to purge %s; Error: %s", queue, err)
+			return n, err
+		}
+		n += purged
+	}
+
+	logrus.Infof("Purged %d dead-lettered messages", n)
+
+	return n, nil
+}
+
+// take acks the selected messages, replaying them first when asked, the others stay in the queues
+func (dl deadLetters) take(ids []string, replay bool) (int, error) {
+	ch, err := dl.conn.Channel()
+	if err != nil {
+		return 0, err
+	}
+	defer ch.Close()
+
+	if err = ch.Confirm(false); err != nil {
+		return 0, err
+	}
+	confirms := ch.NotifyPublish(make(chan amqp.Confirmation, 1))
+
+	selected := map[string]bool{}
+	for _, id := range ids {
+		selected[id] = true
+	}
+
+	n := 0
+	queues, origins := dl.origins()
+	for _, queue := range queues {
+		for {
+			// unselected messages stay unacked, so every message is got once and requeued on close
+			d, ok, err := ch.Get(queue, false)
+			if err != nil {
+				logrus.Errorf("Failed to get a message from %s; Error: %s", queue, err)
+				return n, err
+			}
+			if !ok {
+				break
+			}
+			if ids != nil && !selected[messageId(&d)] {
+				continue
+			}
+
+			if replay {
+				if err = republish(ch, confirms, origins[queue], &d); err != nil {
+					logrus.Errorf("Failed to replay message %s from %s; Error: %s", messageId(&d), queue, err)
+					return n, err
+				}
+			}
+
+			if err = d.Ack(false); err != nil {
+				return n, err
+			}
+			n++
+		}
+	}
+
+	logrus.Infof("Took %d dead-lettered messages, replayed: %t", n, replay)
+
+	return n, nil
+}
+
+// republish publishes the message as it was before its first attempt through the default exchange,
+// so it reaches only its queue and not the other services bound to its exchange, and is retried again when it fails
+func republish(ch *amqp.Channel, confirms <-chan amqp.Confirmation, queue string, d *amqp.Delivery) error {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		switch k {
+		case "x-death", "x-first-death-exchange", "x-first-death-queue", "x-first-death-reason",
+			headerError, headerAttempts, headerOriginalExchange, headerOriginalQueue:
+			continue
+		}
+		headers[k] = v
+	}
+
+	if err := ch.Publish(
+		"",
+		queue,
+		false,
+		false,
+		amqp.Publishing{
+			Headers:         headers,
+			ContentType:     d.ContentType,
+			ContentEncoding: d.ContentEncoding,
+			DeliveryMode:    amqp.Persistent,
+			CorrelationId:   d.CorrelationId,
+			MessageId:       d.MessageId,
+			Timestamp:       d.Timestamp,
+			Type:            d.Type,
+			AppId:           d.AppId,
+			Body:            d.Body,
+		},
+	); err != nil {
+		return err
+	}
+
+	select {
+	case c, ok := <-confirms:
+		if !ok {
+			return amqp.ErrClosed
+		}
+		if !c.Ack {
+			return fmt.Errorf("message %s nacked by rabbitmq", messageId(d))
+		}
+		return nil
+	case <-time.After(confirmTimeout):
+		return fmt.Errorf("message %s not confirmed in %s", messageId(d), confirmTimeout)
+	}
+}
+
+func deadLetter(queue string, d *amqp.Delivery) *DeadLetter {
+	ex, _ := d.Headers[headerOriginalExchange].(string)
+	reason, _ := d.Headers[headerError].(string)
+	attempts, _ := d.Headers[headerAttempts].(int64)
+
+	return &DeadLetter{
+		Id:        messageId(d),
+		Queue:     queue,
+		Exchange:  ex,
+		Reason:    reason,
+		Attempts:  int(attempts),
+		Timestamp: d.Timestamp,
+		Headers:   d.Headers,
+		Body:      d.Body,
+	}
+}
+
+func messageId(d *amqp.Delivery) string {
+	if d.MessageId != "" {
+		return d.MessageId
+	}
+
+	h := fnv.New64a()
+	_, _ = h.Write(d.Body)
+	return fmt.Sprintf("%x", h.Sum64())
+}
@@ -0,0 +1,81 @@
+package amqp
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+const (
+	dedupPurgeInterval = time.Hour
+	dedupTimeout       = 2 * time.Second
+)
+
+// dedupId of the delivery is its message id, or the id of its CloudEvents envelope,
+// scoped by the queue since a message is consumed once from every queue it is routed to.
+// It is empty when the message has neither, such messages are always handled.
+func dedupId(queue string, d *amqp.Delivery) string {
+	id := d.MessageId
+	if id == "" {
+		var envelope struct {
+			Id string `json:"id"`
+		}
+		_ = json.Unmarshal(d.Body, &envelope)
+		id = envelope.Id
+	}
+
+	if id == "" {
+		return ""
+	}
+
+	return fmt.Sprintf("%s:%s", queue, id)
+}
+
+// seen reports whether the message was already handled, when the store fails the message is handled again
+func (r receiver) seen(id string) bool {
+	if id == "" {
+		return false
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dedupTimeout)
+	defer cancel()
+
+	seen, err := r.dedup.Seen(ctx, id)
+	if err != nil {
+		logrus.Errorf("Failed to check message %s for duplicates; Error: %s", id, err)
+		return false
+	}
+
+	return seen
+}
+
+func (r receiver) mark(id string) {
+	if id == "" {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), dedupTimeout)
+	defer cancel()
+
+	if err := r.dedup.Mark(ctx, id); err != nil {
+		logrus.Errorf("Failed to mark message %s as handled; Error: %s", id, err)
+	}
+}
+
+func (r receiver) purgeDedup(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(dedupPurgeInterval):
+		}
+
+		if err := r.dedup.Purge(ctx); err != nil {
+			logrus.Errorf("Failed to purge handled messages; Error: %s", err)
+		}
+	}
+}
@@ -1,17 +1,22 @@
 package amqp
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+
 	"github.com/pejovski/catalog/controller"
 	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
-	"time"
 )
 
-const rejectSleepTime = 5 * time.Second
+// ErrMalformed is a message which can never be handled, it is dead-lettered without being retried
+var ErrMalformed = errors.New("malformed message")
 
+// Handler handles a delivery, the receiver acks it on success and retries or dead-letters it on error
 type Handler interface {
-	RatingUpdated(d *amqp.Delivery)
+	RatingUpdated(ctx context.Context, d *amqp.Delivery) error
 }
 
 type handler struct {
@@ -24,38 +29,37 @@ func NewHandler(c controller.Controller) Handler {
 	}
 }
 
-func (h handler) RatingUpdated(d *amqp.Delivery) {
+type ratingUpdated struct {
+	ProductId string `json:"product_id"`
+}
+
+// ratingUpdatedKey keeps the ratings of a product in order, malformed messages share the empty key
+func ratingUpdatedKey(d *amqp.Delivery) string {
+	var msg ratingUpdated
+	_ = json.Unmarshal(d.Body, &msg)
+	return msg.ProductId
+}
 
-	msq := struct {
-		ProductId string `json:"product_id"`
-	}{}
+func (h handler) RatingUpdated(ctx context.Context, d *amqp.Delivery) error {
+
+	msq := ratingUpdated{}
 
 	err := json.Unmarshal(d.Body, &msq)
 	if err != nil {
 		logrus.Errorln("Failed to read body", err)
-		h.reject(d)
-		return
+		return fmt.Errorf("%w: %s", ErrMalformed, err)
 	}
 
-	err = h.controller.UpdateRating(msq.ProductId)
-	if err != nil {
-		logrus.Errorln("Failed to update product", err)
-		h.reject(d)
-		return
+	if msq.ProductId == "" {
+		logrus.Errorln("Missing product id", string(d.Body))
+		return fmt.Errorf("%w: missing product_id", ErrMalformed)
 	}
 
-	h.ack(d)
-}
-
-func (h handler) reject(d *amqp.Delivery) {
-	time.Sleep(rejectSleepTime)
-	if err := d.Reject(true); err != nil {
-		logrus.Errorln("Failed to reject msg", err)
+	err = h.controller.UpdateRating(ctx, msq.ProductId)
+	if err != nil {
+		logrus.Errorln("Failed to update product", err)
+		return err
 	}
-}
 
-func (h handler) ack(d *amqp.Delivery) {
-	if err := d.Ack(false); err != nil {
-		logrus.Errorln("Failed to ack msg", err)
-	}
+	return nil
 }
@@ -0,0 +1,140 @@
+package amqp
+
+import (
+	"context"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+)
+
+// handle dispatches the deliveries of the queue to the workers until the context is canceled or the deliveries
+// are closed together with the channel. Deliveries with the same key, e.g. of the same product, go to the same
+// worker so they are handled in order, the others are handled in parallel. Within the window the deliveries
+// with the same key are coalesced and handled once, the deliveries without a key are never coalesced.
+// It returns once the deliveries already received are handled, those left in the prefetch buffer
+// are requeued by the broker.
+func (r receiver) handle(ctx context.Context, pub *publisher, queue string, dCh <-chan amqp.Delivery, key func(d *amqp.Delivery) string, window time.Duration, handle func(context.Context, *amqp.Delivery) error) {
+	defer r.running.Done()
+
+	var wg sync.WaitGroup
+	workers := make([]chan []amqp.Delivery, r.workers)
+
+	for i := range workers {
+		// the prefetch bounds the unacked deliveries, so a busy worker never blocks the dispatch
+		workers[i] = make(chan []amqp.Delivery, r.prefetch())
+		wg.Add(1)
+		go func(w <-chan []amqp.Delivery) {
+			defer wg.Done()
+			for batch := range w {
+				r.process(pub, queue, batch, handle)
+			}
+		}(workers[i])
+	}
+
+	// deliveries being coalesced by key, due once the window of the first one passed
+	pending := map[string][]amqp.Delivery{}
+	due := make(chan string)
+	stop := make(chan struct{})
+
+	dispatch := func(k string, batch []amqp.Delivery) {
+		workers[partition(k, len(workers))] <- batch
+	}
+
+	defer func() {
+		close(stop)
+		for k, batch := range pending {
+			dispatch(k, batch)
+		}
+		for _, w := range workers {
+			close(w)
+		}
+		wg.Wait()
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			logrus.Infof("Draining %s", queue)
+			return
+		case k := <-due:
+			dispatch(k, pending[k])
+			delete(pending, k)
+		case d, ok := <-dCh:
+			if !ok {
+				logrus.Warnf("Stopped consuming %s", queue)
+				return
+			}
+
+			k := key(&d)
+			if window <= 0 || k == "" {
+				dispatch(k, []amqp.Delivery{d})
+				continue
+			}
+
+			if batch, ok := pending[k]; ok {
+				pending[k] = append(batch, d)
+				continue
+			}
+
+			pending[k] = []amqp.Delivery{d}
+			time.AfterFunc(window, func() {
+				select {
+				case due <- k:
+				case <-stop:
+				}
+			})
+		}
+	}
+}
+
+// process handles the batch of coalesced deliveries once, with the latest of them, and acks them all
+// once it succeeded, otherwise they are all retried. In-flight deliveries are not canceled on shutdown
+// so their handling is bounded by the timeouts of the handler. Redeliveries of handled messages are acked
+// without being handled again.
+func (r receiver) process(pub *publisher, queue string, batch []amqp.Delivery, handle func(context.Context, *amqp.Delivery) error) {
+	fresh := []*amqp.Delivery{}
+	for i := range batch {
+		d := &batch[i]
+		if id := dedupId(queue, d); r.seen(id) {
+			logrus.Infof("Skipping duplicate message %s", id)
+			ack(d)
+			continue
+		}
+		fresh = append(fresh, d)
+	}
+
+	if len(fresh) == 0 {
+		return
+	}
+
+	if len(fresh) > 1 {
+		logrus.Infof("Handling %d coalesced messages from %s once", len(fresh), queue)
+	}
+
+	if err := handle(context.Background(), fresh[len(fresh)-1]); err != nil {
+		for _, d := range fresh {
+			r.retry(pub, queue, d, err)
+		}
+		return
+	}
+
+	for _, d := range fresh {
+		r.mark(dedupId(queue, d))
+		ack(d)
+	}
+}
+
+func ack(d *amqp.Delivery) {
+	if err := d.Ack(false); err != nil {
+		logrus.Errorln("Failed to ack msg", err)
+	}
+}
+
+func partition(key string, n int) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32() % uint32(n))
+}
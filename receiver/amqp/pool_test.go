@@ -0,0 +1,177 @@
+package amqp
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/pejovski/catalog/repository/memory"
+)
+
+type acks struct {
+	mu   sync.Mutex
+	tags []uint64
+}
+
+func (a *acks) Ack(tag uint64, multiple bool) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.tags = append(a.tags, tag)
+	return nil
+}
+
+func (a *acks) Nack(tag uint64, multiple bool, requeue bool) error {
+	return nil
+}
+
+func (a *acks) Reject(tag uint64, requeue bool) error {
+	return nil
+}
+
+func TestHandleKeepsOrderPerKey(t *testing.T) {
+	r := receiver{workers: 4, running: &sync.WaitGroup{}, dedup: memory.NewDedup(time.Hour, 100)}
+	a := &acks{}
+
+	var mu sync.Mutex
+	handled := map[string][]int{}
+
+	dCh := make(chan amqp.Delivery, 100)
+	for i := 0; i < 100; i++ {
+		dCh <- amqp.Delivery{
+			Acknowledger: a,
+			DeliveryTag:  uint64(i),
+			Body:         []byte(fmt.Sprintf(`{"product_id":"%d","n":%d}`, i%5, i)),
+		}
+	}
+	close(dCh)
+
+	r.running.Add(1)
+	r.handle(context.Background(), nil, "rating_updated:catalog", dCh, ratingUpdatedKey, 0, func(ctx context.Context, d *amqp.Delivery) error {
+		key := ratingUpdatedKey(d)
+		mu.Lock()
+		defer mu.Unlock()
+		handled[key] = append(handled[key], int(d.DeliveryTag))
+		return nil
+	})
+
+	if len(a.tags) != 100 {
+		t.Errorf("Expected 100 acks, got %d", len(a.tags))
+	}
+	for key, tags := range handled {
+		for i := 1; i < len(tags); i++ {
+			if tags[i] < tags[i-1] {
+				t.Errorf("Deliveries of %s handled out of order: %v", key, tags)
+				break
+			}
+		}
+	}
+}
+
+func TestHandleDrainsOnCancel(t *testing.T) {
+	r := receiver{workers: 2, running: &sync.WaitGroup{}, dedup: memory.NewDedup(time.Hour, 100)}
+	a := &acks{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	dCh := make(chan amqp.Delivery)
+	release := make(chan struct{})
+
+	r.running.Add(1)
+	done := make(chan struct{})
+	go func() {
+		r.handle(ctx, nil, "rating_updated:catalog", dCh, ratingUpdatedKey, 0, func(ctx context.Context, d *amqp.Delivery) error {
+			<-release
+			return nil
+		})
+		close(done)
+	}()
+
+	dCh <- amqp.Delivery{Acknowledger: a, DeliveryTag: 1, Body: []byte(`{"product_id":"1"}`)}
+	cancel()
+
+	select {
+	case <-done:
+		t.Fatal("Returned before the in-flight delivery was handled")
+	default:
+	}
+
+	close(release)
+	<-done
+
+	if len(a.tags) != 1 {
+		t.Errorf("Expected the in-flight delivery to be acked, got %d acks", len(a.tags))
+	}
+}
+
+func TestHandleSkipsDuplicates(t *testing.T) {
+	r := receiver{workers: 2, running: &sync.WaitGroup{}, dedup: memory.NewDedup(time.Hour, 100)}
+	a := &acks{}
+
+	dCh := make(chan amqp.Delivery, 4)
+	dCh <- amqp.Delivery{Acknowledger: a, DeliveryTag: 1, MessageId: "1", Body: []byte(`{"product_id":"1"}`)}
+	dCh <- amqp.Delivery{Acknowledger: a, DeliveryTag: 2, MessageId: "1", Body: []byte(`{"product_id":"1"}`)}
+	// the id of the envelope is used without a message id
+	dCh <- amqp.Delivery{Acknowledger: a, DeliveryTag: 3, Body: []byte(`{"id":"2","product_id":"1"}`)}
+	dCh <- amqp.Delivery{Acknowledger: a, DeliveryTag: 4, Body: []byte(`{"id":"2","product_id":"1"}`)}
+	close(dCh)
+
+	handled := 0
+	r.running.Add(1)
+	r.handle(context.Background(), nil, "rating_updated:catalog", dCh, ratingUpdatedKey, 0, func(ctx context.Context, d *amqp.Delivery) error {
+		handled++
+		return nil
+	})
+
+	if handled != 2 {
+		t.Errorf("Expected 2 messages handled, got %d", handled)
+	}
+	if len(a.tags) != 4 {
+		t.Errorf("Expected all 4 deliveries acked, got %d", len(a.tags))
+	}
+}
+
+func TestHandleCoalesces(t *testing.T) {
+	r := receiver{workers: 2, running: &sync.WaitGroup{}, dedup: memory.NewDedup(time.Hour, 100)}
+	a := &acks{}
+
+	dCh := make(chan amqp.Delivery, 6)
+	for i := 0; i < 5; i++ {
+		dCh <- amqp.Delivery{Acknowledger: a, DeliveryTag: uint64(i), Body: []byte(`{"product_id":"1"}`)}
+	}
+	dCh <- amqp.Delivery{Acknowledger: a, DeliveryTag: 5, Body: []byte(`{"product_id":"2"}`)}
+
+	var mu sync.Mutex
+	handled := map[string][]uint64{}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+
+	r.running.Add(1)
+	go func() {
+		r.handle(ctx, nil, "rating_updated:catalog", dCh, ratingUpdatedKey, 50*time.Millisecond, func(ctx context.Context, d *amqp.Delivery) error {
+			mu.Lock()
+			defer mu.Unlock()
+			key := ratingUpdatedKey(d)
+			handled[key] = append(handled[key], d.DeliveryTag)
+			return nil
+		})
+		close(done)
+	}()
+
+	time.Sleep(150 * time.Millisecond)
+	cancel()
+	<-done
+
+	if tags := handled["1"]; len(tags) != 1 || tags[0] != 4 {
+		t.Errorf("Expected product 1 handled once with the latest delivery, got %v", tags)
+	}
+	if tags := handled["2"]; len(tags) != 1 {
+		t.Errorf("Expected product 2 handled once, got %v", tags)
+	}
+	if len(a.tags) != 6 {
+		t.Errorf("Expected all 6 deliveries acked, got %d", len(a.tags))
+	}
+}
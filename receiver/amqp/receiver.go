@@ -1,107 +1,145 @@
 package amqp
 
 import (
-	"fmt"
+	"context"
+	"sync"
+	"time"
+
 	"github.com/sirupsen/logrus"
 	"github.com/streadway/amqp"
+
+	"github.com/pejovski/catalog/pkg/rabbitmq"
+	recv "github.com/pejovski/catalog/receiver"
+	"github.com/pejovski/catalog/repository"
 )
 
 const (
-	exRatingUpdated = "rating_updated"
-
-	queueName = "catalog"
+	msgRatingUpdated = "rating_updated"
 
-	exKind        = "fanout"
 	prefetchCount = 5
+
+	DefaultWorkers = 5
+	// DefaultCoalesceWindow is how long the ratings of a product are collected before they are handled once
+	DefaultCoalesceWindow = time.Second
+
+	// held by the coalescing, the messages of a burst beyond it are received only after the held ones are acked
+	coalescePrefetch = 50
 )
 
-type Receiver interface {
-	Receive()
-}
+// Messages consumed by the catalog, each from the queue the topology maps it to
+var Messages = []string{msgRatingUpdated}
 
 type receiver struct {
-	ch      *amqp.Channel
-	handler Handler
+	conn rabbitmq.Connection
+	// topology including the retry and dead-letter queues, see Topology
+	topology *rabbitmq.Topology
+	handler  Handler
+	policy   RetryPolicy
+	// workers of every queue
+	workers int
+	// rating updates of a product received within the window are coalesced, 0 disables it
+	window time.Duration
+	dedup  repository.Dedup
+	// consumers of all connections, the receiver is drained once they stopped
+	running *sync.WaitGroup
+	drained chan struct{}
 }
 
-func NewReceiver(ch *amqp.Channel, h Handler) Receiver {
+// NewReceiver consumes the Messages from the queues of the topology, which must be valid for them
+func NewReceiver(conn rabbitmq.Connection, t *rabbitmq.Topology, h Handler, p RetryPolicy, workers int, window time.Duration, d repository.Dedup) recv.Receiver {
 	return receiver{
-		ch:      ch,
-		handler: h,
+		conn:     conn,
+		topology: Topology(t, p),
+		handler:  h,
+		policy:   p,
+		workers:  workers,
+		window:   window,
+		dedup:    d,
+		running:  &sync.WaitGroup{},
+		drained:  make(chan struct{}),
 	}
 }
 
-func (r receiver) Receive() {
-	if err := r.ch.Qos(
-		prefetchCount,
-		0,
-		false,
-	); err != nil {
-		logrus.Fatalln("Failed to set Qos", err)
-	}
+// Receive declares the topology and starts consuming on every connection, the consumers stop when
+// the connection drops and are started again once it is back. The connection must stay open until the receiver is drained.
+func (r receiver) Receive(ctx context.Context) {
+	r.conn.OnConnect("receiver", func(ch *amqp.Channel) error {
+		return r.consume(ctx, ch)
+	})
 
-	exchanges := []string{exRatingUpdated}
+	go r.purgeDedup(ctx)
 
-	for _, ex := range exchanges {
+	go func() {
+		<-ctx.Done()
+		r.running.Wait()
+		close(r.drained)
+	}()
+}
 
-		dCh := r.deliveryCh(ex)
+func (r receiver) Drained() <-chan struct{} {
+	return r.drained
+}
 
-		switch ex {
-		case exRatingUpdated:
-			go func() {
-				for d := range dCh {
-					r.handler.RatingUpdated(&d)
-				}
-			}()
-		default:
-			return
-		}
+// prefetch lets every worker have a delivery in hand, and leaves room for the coalescing
+func (r receiver) prefetch() int {
+	n := prefetchCount
+	if r.window > 0 {
+		n = coalescePrefetch
 	}
-
+	if r.workers > n {
+		return r.workers
+	}
+	return n
 }
 
-func (r *receiver) deliveryCh(ex string) <-chan amqp.Delivery {
-	queue := fmt.Sprintf("%s:%s", ex, queueName)
+func (r receiver) consume(ctx context.Context, ch *amqp.Channel) error {
+	// a reconnect while draining must not start consumers, their deliveries would never be handled
+	if ctx.Err() != nil {
+		logrus.Infoln("Receiver is shutting down, not consuming on the new connection")
+		return nil
+	}
 
-	_, err := r.ch.QueueDeclare(
-		queue,
-		true,
-		false,
-		false,
+	if err := ch.Qos(
+		r.prefetch(),
+		0,
 		false,
-		nil,
-	)
-	if err != nil {
-		logrus.Fatalln("Failed to declare a queue", err)
+	); err != nil {
+		logrus.Errorln("Failed to set Qos", err)
+		return err
 	}
 
-	err = r.ch.ExchangeDeclare(
-		ex,
-		exKind,
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-	if err != nil {
-		logrus.Fatalf("%s %s: %s", "Failed to declare an exchange", ex, err)
+	if err := r.topology.Declare(ch); err != nil {
+		return err
 	}
 
-	err = r.ch.QueueBind(
-		queue,
-		"",
-		ex,
-		false,
-		nil,
-	)
+	// failed messages are published to the retry queues on the same channel
+	pub, err := newPublisher(ch)
 	if err != nil {
-		logrus.Fatalln("Failed to bind a queue", err)
+		return err
 	}
 
-	logrus.Infof("RabbitMQ queue %s declared\n", queue)
+	for _, msg := range Messages {
+		queue := r.topology.Consume[msg]
+
+		dCh, err := r.deliveryCh(ch, queue)
+		if err != nil {
+			return err
+		}
 
-	msgs, err := r.ch.Consume(
+		switch msg {
+		case msgRatingUpdated:
+			r.running.Add(1)
+			go r.handle(ctx, pub, queue, dCh, ratingUpdatedKey, r.window, r.handler.RatingUpdated)
+		default:
+			return nil
+		}
+	}
+
+	return nil
+}
+
+func (r receiver) deliveryCh(ch *amqp.Channel, queue string) (<-chan amqp.Delivery, error) {
+	msgs, err := ch.Consume(
 		queue,
 		"",
 		false,
@@ -111,8 +149,11 @@ func (r *receiver) deliveryCh(ex string) <-chan amqp.Delivery {
 		nil,
 	)
 	if err != nil {
-		logrus.Fatalln("Failed to register a consumer", err)
+		logrus.Errorln("Failed to register a consumer", err)
+		return nil, err
 	}
 
-	return msgs
+	logrus.Infof("Consuming RabbitMQ queue %s", queue)
+
+	return msgs, nil
 }
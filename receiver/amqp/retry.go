@@ -0,0 +1,209 @@
+package amqp
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+
+	"github.com/pejovski/catalog/pkg/rabbitmq"
+)
+
+const (
+	// headers of dead-lettered and retried messages
+	headerError            = "x-error"
+	headerAttempts         = "x-attempts"
+	headerOriginalExchange = "x-original-exchange"
+	headerOriginalQueue    = "x-original-queue"
+
+	// confirmTimeout bounds the wait for the broker to confirm a retried or replayed message
+	confirmTimeout = 5 * time.Second
+	// confirmations arriving after their publish timed out wait here for the next publish,
+	// a full buffer would block the whole connection
+	confirmBuffer = 100
+)
+
+// RetryPolicy of failed messages, the delay doubles on every attempt
+type RetryPolicy struct {
+	// MaxAttempts including the first one, the message is dead-lettered after the last one
+	MaxAttempts int
+	// Delay before the first retry
+	Delay time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{MaxAttempts: 5, Delay: time.Second}
+
+// delay before the retry of the given failed attempt
+func (p RetryPolicy) delay(attempt int) time.Duration {
+	return p.Delay * time.Duration(1<<uint(attempt-1))
+}
+
+// retryQueue holds the messages of the failed attempt until its delay expires,
+// they are dead-lettered back to the queue through the default exchange
+func retryQueue(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%s", queue, delay)
+}
+
+// deadLetterQueue keeps the messages which ran out of attempts until they are replayed or purged
+func deadLetterQueue(queue string) string {
+	return fmt.Sprintf("%s.dlq", queue)
+}
+
+// Topology adds the retry queues of every attempt and the dead-letter queue of every consumed queue
+func Topology(t *rabbitmq.Topology, p RetryPolicy) *rabbitmq.Topology {
+	extended := *t
+	extended.Queues = append([]*rabbitmq.Queue{}, t.Queues...)
+
+	defaultExchange := ""
+
+	for _, msg := range Messages {
+		queue := t.Consume[msg]
+
+		for attempt := 1; attempt < p.MaxAttempts; attempt++ {
+			delay := p.delay(attempt)
+			extended.Queues = append(extended.Queues, &rabbitmq.Queue{
+				Name:                 retryQueue(queue, delay),
+				Durable:              true,
+				DeadLetterExchange:   &defaultExchange,
+				DeadLetterRoutingKey: queue,
+				MessageTTL:           int64(delay / time.Millisecond),
+			})
+		}
+
+		extended.Queues = append(extended.Queues, &rabbitmq.Queue{Name: deadLetterQueue(queue), Durable: true})
+	}
+
+	return &extended
+}
+
+// publisher publishes the retried messages on the consumer channel in confirm mode, one at a time
+// so the confirmation of a message is the one with the latest delivery tag
+type publisher struct {
+	mu       sync.Mutex
+	ch       *amqp.Channel
+	confirms chan amqp.Confirmation
+	// delivery tag of the last publish
+	tag uint64
+}
+
+func newPublisher(ch *amqp.Channel) (*publisher, error) {
+	if err := ch.Confirm(false); err != nil {
+		logrus.Errorln("Failed to put the consumer channel in confirm mode", err)
+		return nil, err
+	}
+
+	return &publisher{ch: ch, confirms: ch.NotifyPublish(make(chan amqp.Confirmation, confirmBuffer))}, nil
+}
+
+// publish sends the message to the queue through the default exchange and waits until the broker confirmed it
+func (p *publisher) publish(queue string, msg amqp.Publishing) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if err := p.ch.Publish("", queue, false, false, msg); err != nil {
+		return err
+	}
+	p.tag++
+
+	timeout := time.NewTimer(confirmTimeout)
+	defer timeout.Stop()
+
+	for {
+		select {
+		case c, ok := <-p.confirms:
+			if !ok {
+				return amqp.ErrClosed
+			}
+			// confirmations of earlier messages which timed out are skipped
+			if c.DeliveryTag < p.tag {
+				continue
+			}
+			if !c.Ack {
+				return errors.New("message nacked by rabbitmq")
+			}
+			return nil
+		case <-timeout.C:
+			return fmt.Errorf("message not confirmed in %s", confirmTimeout)
+		}
+	}
+}
+
+// retry acks the failed delivery once it is confirmed in the retry queue of its attempt,
+// or in the dead-letter queue when it ran out of attempts or can never succeed.
+// When it cannot be published it is requeued, so it is never lost.
+func (r receiver) retry(pub *publisher, queue string, d *amqp.Delivery, reason error) {
+	attempt := attempts(d.Headers, queue) + 1
+
+	target := retryQueue(queue, r.policy.delay(attempt))
+	if attempt >= r.policy.MaxAttempts || errors.Is(reason, ErrMalformed) {
+		target = deadLetterQueue(queue)
+		logrus.Errorf("Dead-lettering message %s from %s after %d attempts; Error: %s", d.MessageId, queue, attempt, reason)
+	}
+
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[headerError] = reason.Error()
+	headers[headerAttempts] = int64(attempt)
+	headers[headerOriginalQueue] = queue
+	// retried messages come back through the default exchange
+	if _, ok := headers[headerOriginalExchange]; !ok {
+		headers[headerOriginalExchange] = d.Exchange
+	}
+
+	if err := pub.publish(
+		target,
+		amqp.Publishing{
+			Headers:         headers,
+			ContentType:     d.ContentType,
+			ContentEncoding: d.ContentEncoding,
+			DeliveryMode:    amqp.Persistent,
+			CorrelationId:   d.CorrelationId,
+			MessageId:       d.MessageId,
+			Timestamp:       d.Timestamp,
+			Type:            d.Type,
+			AppId:           d.AppId,
+			Body:            d.Body,
+		},
+	); err != nil {
+		logrus.Errorf("Failed to publish message %s to %s, requeueing; Error: %s", d.MessageId, target, err)
+		if err := d.Nack(false, true); err != nil {
+			logrus.Errorln("Failed to nack msg", err)
+		}
+		return
+	}
+
+	if err := d.Ack(false); err != nil {
+		logrus.Errorln("Failed to ack msg", err)
+	}
+}
+
+// attempts counts the retries of the message from the x-death header, every expiry in a retry queue is one
+func attempts(headers amqp.Table, queue string) int {
+	deaths, ok := headers["x-death"].([]interface{})
+	if !ok {
+		return 0
+	}
+
+	n := 0
+	for _, death := range deaths {
+		t, ok := death.(amqp.Table)
+		if !ok {
+			continue
+		}
+		q, _ := t["queue"].(string)
+		reason, _ := t["reason"].(string)
+		if reason != "expired" || !strings.HasPrefix(q, queue+".retry.") {
+			continue
+		}
+		count, _ := t["count"].(int64)
+		n += int(count)
+	}
+
+	return n
+}
@@ -0,0 +1,74 @@
+package amqp
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	"github.com/pejovski/catalog/pkg/rabbitmq"
+)
+
+func TestAttempts(t *testing.T) {
+	queue := "rating_updated:catalog"
+
+	tests := []struct {
+		headers  amqp.Table
+		attempts int
+	}{
+		{nil, 0},
+		{amqp.Table{"x-death": "invalid"}, 0},
+		{amqp.Table{"x-death": []interface{}{
+			amqp.Table{"queue": retryQueue(queue, time.Second), "reason": "expired", "count": int64(1)},
+			amqp.Table{"queue": retryQueue(queue, 2*time.Second), "reason": "expired", "count": int64(2)},
+			// deaths in other queues are not attempts of this one
+			amqp.Table{"queue": "rating_updated:other.retry.1s", "reason": "expired", "count": int64(4)},
+			amqp.Table{"queue": queue, "reason": "rejected", "count": int64(1)},
+		}}, 3},
+	}
+
+	for _, tt := range tests {
+		if n := attempts(tt.headers, queue); n != tt.attempts {
+			t.Errorf("Expected %d attempts, got %d", tt.attempts, n)
+		}
+	}
+}
+
+func TestRetryPolicyDelay(t *testing.T) {
+	p := RetryPolicy{MaxAttempts: 4, Delay: time.Second}
+
+	for attempt, delay := range map[int]time.Duration{1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second} {
+		if d := p.delay(attempt); d != delay {
+			t.Errorf("Expected delay %s after attempt %d, got %s", delay, attempt, d)
+		}
+	}
+}
+
+func TestTopology(t *testing.T) {
+	queue := "rating_updated:catalog"
+	declared := &rabbitmq.Topology{
+		Queues:  []*rabbitmq.Queue{{Name: queue, Durable: true}},
+		Consume: map[string]string{msgRatingUpdated: queue},
+	}
+
+	extended := Topology(declared, RetryPolicy{MaxAttempts: 3, Delay: time.Second})
+
+	if len(declared.Queues) != 1 {
+		t.Errorf("Expected the declared topology to be left as it is, got %d queues", len(declared.Queues))
+	}
+
+	names := []string{}
+	for _, q := range extended.Queues {
+		names = append(names, q.Name)
+	}
+	expected := []string{queue, queue + ".retry.1s", queue + ".retry.2s", queue + ".dlq"}
+	if strings.Join(names, ",") != strings.Join(expected, ",") {
+		t.Fatalf("Expected queues %v, got %v", expected, names)
+	}
+
+	retry := extended.Queues[2]
+	if retry.MessageTTL != 2000 || retry.DeadLetterExchange == nil || *retry.DeadLetterExchange != "" || retry.DeadLetterRoutingKey != queue {
+		t.Errorf("Expected %s to expire into %s after 2000ms, got %+v", retry.Name, queue, retry)
+	}
+}
@@ -0,0 +1,118 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/streadway/amqp"
+
+	myerr "github.com/pejovski/catalog/error"
+	"github.com/pejovski/catalog/pkg/bus"
+	recv "github.com/pejovski/catalog/receiver"
+	amqpReceiver "github.com/pejovski/catalog/receiver/amqp"
+)
+
+const (
+	exRatingUpdated = "rating_updated"
+
+	retryDelay = 5 * time.Second
+)
+
+// retry is a failed message waiting for its next attempt
+type retry struct {
+	body    []byte
+	attempt int
+}
+
+// receiver feeds messages from an in-process bus to the amqp handler,
+// so standalone mode runs the same message handling as production
+type receiver struct {
+	bus     bus.Bus
+	handler amqpReceiver.Handler
+	running *sync.WaitGroup
+	drained chan struct{}
+}
+
+func NewReceiver(b bus.Bus, h amqpReceiver.Handler) recv.Receiver {
+	return receiver{
+		bus:     b,
+		handler: h,
+		running: &sync.WaitGroup{},
+		drained: make(chan struct{}),
+	}
+}
+
+func (r receiver) Receive(ctx context.Context) {
+	exchanges := []string{exRatingUpdated}
+
+	for _, ex := range exchanges {
+
+		msgs := r.bus.Subscribe(ex)
+
+		switch ex {
+		case exRatingUpdated:
+			r.running.Add(1)
+			go r.consume(ctx, ex, msgs, r.handler.RatingUpdated)
+		default:
+			return
+		}
+	}
+
+	go func() {
+		<-ctx.Done()
+		r.running.Wait()
+		close(r.drained)
+	}()
+}
+
+func (r receiver) Drained() <-chan struct{} {
+	return r.drained
+}
+
+func (r receiver) consume(ctx context.Context, ex string, msgs <-chan []byte, handle func(context.Context, *amqp.Delivery) error) {
+	defer r.running.Done()
+
+	// there are no retry queues in-process, failed messages come back here after a while
+	retries := make(chan retry)
+
+	for {
+		m := retry{attempt: 1}
+
+		select {
+		case <-ctx.Done():
+			return
+		case m.body = <-msgs:
+		case m = <-retries:
+		}
+
+		d := amqp.Delivery{
+			ContentType: "text/plain",
+			Exchange:    ex,
+			Body:        m.body,
+		}
+		err := handle(context.Background(), &d)
+		if err == nil {
+			continue
+		}
+		if m.attempt >= amqpReceiver.DefaultRetryPolicy.MaxAttempts || permanent(err) {
+			logrus.Errorf("Dropping message from %s after %d attempts; Error: %s", ex, m.attempt, err)
+			continue
+		}
+
+		next := retry{body: m.body, attempt: m.attempt + 1}
+		time.AfterFunc(retryDelay, func() {
+			select {
+			case retries <- next:
+			case <-ctx.Done():
+			}
+		})
+	}
+}
+
+// permanent tells if the message can never be handled, e.g. a rating of a deleted product
+func permanent(err error) bool {
+	return errors.Is(err, amqpReceiver.ErrMalformed) || errors.Is(err, myerr.ErrNotFound)
+}
@@ -0,0 +1,11 @@
+package receiver
+
+import "context"
+
+type Receiver interface {
+	// Receive consumes messages in goroutines until the context is canceled,
+	// the messages already handed to the handlers are still handled and acked
+	Receive(ctx context.Context)
+	// Drained is closed once the context is canceled and the in-flight messages are handled
+	Drained() <-chan struct{}
+}
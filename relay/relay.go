@@ -0,0 +1,157 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/pejovski/catalog/emitter"
+	"github.com/pejovski/catalog/model"
+	"github.com/pejovski/catalog/repository"
+)
+
+const (
+	DefaultInterval = time.Second
+	// DefaultGrace is how long a pending event waits for its write before it is published anyway,
+	// it must be longer than the write timeout of the repository
+	DefaultGrace = 30 * time.Second
+
+	batchSize  = 100
+	maxBackoff = time.Minute
+
+	retention     = 24 * time.Hour
+	purgeInterval = time.Hour
+)
+
+// errInvalidEvent is an event that can never be published, e.g. of a type unknown to this version
+var errInvalidEvent = errors.New("invalid event")
+
+// Relay publishes the events of the outbox, an event is marked as sent only after the emitter
+// published it, so every event is published at least once
+type Relay interface {
+	// Run blocks until the context is canceled
+	Run(ctx context.Context)
+}
+
+type relay struct {
+	outbox   repository.Outbox
+	emitter  emitter.Emitter
+	interval time.Duration
+	grace    time.Duration
+}
+
+func NewRelay(o repository.Outbox, e emitter.Emitter, interval time.Duration, grace time.Duration) Relay {
+	return relay{outbox: o, emitter: e, interval: interval, grace: grace}
+}
+
+func (r relay) Run(ctx context.Context) {
+	backoff := r.interval
+	purged := time.Now()
+
+	for {
+		n, err := r.relay(ctx)
+
+		wait := r.interval
+		switch {
+		case err != nil:
+			// the order of events is kept, so nothing newer is published until the failed one goes through
+			backoff *= 2
+			if backoff > maxBackoff {
+				backoff = maxBackoff
+			}
+			wait = backoff
+			logrus.Errorf("Failed to relay events, retrying in %s; Error: %s", wait, err)
+		case n == batchSize:
+			// there are more events waiting
+			backoff, wait = r.interval, 0
+		default:
+			backoff = r.interval
+		}
+
+		if time.Since(purged) > purgeInterval {
+			if err := r.outbox.Purge(ctx, time.Now().Add(-retention)); err != nil {
+				logrus.Errorf("Failed to purge sent events; Error: %s", err)
+			}
+			purged = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(wait):
+		}
+	}
+}
+
+// relay publishes a batch of events in order and returns how many were published or parked
+func (r relay) relay(ctx context.Context) (int, error) {
+	events, err := r.outbox.Unsent(ctx, time.Now().Add(-r.grace), batchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := []string{}
+	parked := 0
+	var failure error
+
+	for _, e := range events {
+		err := r.publish(e)
+		if errors.Is(err, errInvalidEvent) {
+			// blocking the outbox on it would stop every other event
+			logrus.Errorf("Skipping event %s; Error: %s", e.Id, err)
+			sent = append(sent, e.Id)
+			continue
+		}
+		if errors.Is(err, emitter.ErrUnroutable) {
+			// like an invalid event it would stop every other event, it is set aside instead of marked as sent
+			if err := r.outbox.Park(ctx, e.Id, err); err != nil {
+				logrus.Errorf("Failed to park event %s; Error: %s", e.Id, err)
+				failure = fmt.Errorf("event %s: %w", e.Id, err)
+				break
+			}
+			logrus.Errorf("Parked event %s; Error: %s", e.Id, err)
+			parked++
+			continue
+		}
+		if err != nil {
+			if err := r.outbox.Failed(ctx, e.Id, err); err != nil {
+				logrus.Errorf("Failed to record failed attempt of event %s; Error: %s", e.Id, err)
+			}
+			failure = fmt.Errorf("event %s (attempt %d): %w", e.Id, e.Attempts+1, err)
+			break
+		}
+		sent = append(sent, e.Id)
+	}
+
+	// events published but not marked are published again, consumers must tolerate duplicates
+	if err := r.outbox.Sent(ctx, sent...); err != nil {
+		return 0, err
+	}
+
+	return len(sent) + parked, failure
+}
+
+func (r relay) publish(e *model.Event) error {
+	switch e.Type {
+	case model.EventProductCreated, model.EventProductUpdated, model.EventProductPriceUpdated:
+		if e.After == nil {
+			return fmt.Errorf("%w: %s without the product after the change", errInvalidEvent, e.Type)
+		}
+	}
+
+	switch e.Type {
+	case model.EventProductCreated:
+		return r.emitter.ProductCreated(e)
+	case model.EventProductUpdated:
+		return r.emitter.ProductUpdated(e)
+	case model.EventProductDeleted:
+		return r.emitter.ProductDeleted(e)
+	case model.EventProductPriceUpdated:
+		return r.emitter.ProductPriceUpdated(e)
+	default:
+		return fmt.Errorf("%w: unknown type %s", errInvalidEvent, e.Type)
+	}
+}
@@ -0,0 +1,111 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pejovski/catalog/emitter"
+	"github.com/pejovski/catalog/model"
+	"github.com/pejovski/catalog/repository/memory"
+)
+
+type recordingEmitter struct {
+	published []string
+	// publishing fails while positive
+	failures int
+	// events of this type are never routed
+	unroutable string
+}
+
+func (e *recordingEmitter) publish(event string) error {
+	if e.failures > 0 {
+		e.failures--
+		return errors.New("broker down")
+	}
+	if e.unroutable != "" && strings.HasPrefix(event, e.unroutable+":") {
+		return emitter.ErrUnroutable
+	}
+	e.published = append(e.published, event)
+	return nil
+}
+
+func (e *recordingEmitter) ProductCreated(ev *model.Event) error {
+	return e.publish("created:" + ev.ProductId)
+}
+
+func (e *recordingEmitter) ProductPriceUpdated(ev *model.Event) error {
+	return e.publish("price_updated:" + ev.ProductId)
+}
+
+func (e *recordingEmitter) ProductUpdated(ev *model.Event) error {
+	return e.publish("updated:" + ev.ProductId)
+}
+
+func (e *recordingEmitter) ProductDeleted(ev *model.Event) error {
+	return e.publish("deleted:" + ev.ProductId)
+}
+
+func TestRelay(t *testing.T) {
+	ctx := context.Background()
+	o := memory.NewOutbox()
+	e := &recordingEmitter{failures: 1}
+	r := relay{outbox: o, emitter: e, grace: time.Minute}
+
+	now := time.Now()
+	_ = o.Add(ctx,
+		&model.Event{Id: "0", Type: model.EventProductCreated, ProductId: "a", After: &model.Product{Id: "a"}, CreatedAt: now.Add(-time.Millisecond)},
+		&model.Event{Id: "1", Type: model.EventProductUpdated, ProductId: "a", After: &model.Product{Id: "a"}, CreatedAt: now},
+		&model.Event{Id: "2", Type: "unknown", ProductId: "a", CreatedAt: now.Add(time.Millisecond)},
+		&model.Event{Id: "3", Type: model.EventProductDeleted, ProductId: "a", CreatedAt: now.Add(2 * time.Millisecond)},
+		// pending within the grace period, its write may still be running
+		&model.Event{Id: "4", Type: model.EventProductDeleted, ProductId: "b", CreatedAt: now},
+	)
+	_ = o.Ready(ctx, "0", "1", "2", "3")
+
+	if _, err := r.relay(ctx); err == nil {
+		t.Fatal("Expected the first attempt to fail")
+	}
+	if len(e.published) != 0 {
+		t.Fatalf("Expected nothing after the failed event to be published, got %v", e.published)
+	}
+
+	if _, err := r.relay(ctx); err != nil {
+		t.Fatalf("Expected the retry to succeed, got %s", err)
+	}
+	if len(e.published) != 3 || e.published[0] != "created:a" || e.published[1] != "updated:a" || e.published[2] != "deleted:a" {
+		t.Fatalf("Expected the ready events in order, got %v", e.published)
+	}
+
+	if n, err := r.relay(ctx); n != 0 || err != nil {
+		t.Errorf("Expected sent events not to be published again, got %d %v", n, err)
+	}
+}
+
+func TestRelayUnroutable(t *testing.T) {
+	ctx := context.Background()
+	o := memory.NewOutbox()
+	e := &recordingEmitter{unroutable: "deleted"}
+	r := relay{outbox: o, emitter: e, grace: time.Minute}
+
+	now := time.Now()
+	_ = o.Add(ctx,
+		&model.Event{Id: "0", Type: model.EventProductDeleted, ProductId: "a", CreatedAt: now.Add(-time.Millisecond)},
+		&model.Event{Id: "1", Type: model.EventProductCreated, ProductId: "b", After: &model.Product{Id: "b"}, CreatedAt: now},
+	)
+	_ = o.Ready(ctx, "0", "1")
+
+	if n, err := r.relay(ctx); n != 2 || err != nil {
+		t.Fatalf("Expected the unroutable event not to block the next one, got %d %v", n, err)
+	}
+	if len(e.published) != 1 || e.published[0] != "created:b" {
+		t.Fatalf("Expected the event after the unroutable one to be published, got %v", e.published)
+	}
+
+	// the parked event is not retried
+	if n, err := r.relay(ctx); n != 0 || err != nil {
+		t.Errorf("Expected the parked event not to be published again, got %d %v", n, err)
+	}
+}
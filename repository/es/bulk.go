@@ -0,0 +1,109 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/segmentio/ksuid"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pejovski/catalog/model"
+)
+
+// bulkBatchSize is the number of operations sent in a single _bulk request
+const bulkBatchSize = 500
+
+// Bulk sends the operations in batches, a failed batch marks all of its operations
+// as failed and the remaining batches are still executed
+func (r repository) Bulk(ctx context.Context, ops []*model.BulkOperation) ([]*model.BulkResult, error) {
+	results := []*model.BulkResult{}
+
+	for start := 0; start < len(ops); start += bulkBatchSize {
+		end := start + bulkBatchSize
+		if end > len(ops) {
+			end = len(ops)
+		}
+
+		batch, err := r.bulk(ctx, ops[start:end])
+		if err != nil {
+			logrus.Errorf("Failed to execute bulk batch %d-%d. Error: %s", start, end, err)
+			batch = []*model.BulkResult{}
+			for _, op := range ops[start:end] {
+				batch = append(batch, &model.BulkResult{
+					Type:   op.Type,
+					Id:     op.Product.Id,
+					Status: http.StatusServiceUnavailable,
+					Error:  fmt.Sprintf("batch failed: %s", err),
+				})
+			}
+		}
+
+		results = append(results, batch...)
+	}
+
+	return results, nil
+}
+
+func (r repository) bulk(ctx context.Context, ops []*model.BulkOperation) ([]*model.BulkResult, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+
+	for _, op := range ops {
+		a := BulkAction{Index: index, Id: op.Product.Id}
+		if op.Type == model.OpCreate && a.Id == "" {
+			a.Id = ksuid.New().String()
+		}
+		if v := op.Product.Version; v != nil && op.Type != model.OpCreate {
+			a.IfSeqNo, a.IfPrimaryTerm = &v.SeqNo, &v.PrimaryTerm
+		}
+
+		if err := enc.Encode(map[string]BulkAction{op.Type: a}); err != nil {
+			return nil, err
+		}
+
+		var err error
+		switch op.Type {
+		case model.OpCreate:
+			err = enc.Encode(mapProductToDocument(op.Product))
+		case model.OpUpdate:
+			err = enc.Encode(Update{Doc: mapProductToDocument(op.Product)})
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Bulk)
+	defer cancel()
+
+	res, err := r.client.Bulk(&buf, r.client.Bulk.WithIndex(index), r.client.Bulk.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		logrus.Errorf("Error in the bulk response. Status code: %d. Response: %s", res.StatusCode, res.String())
+		return nil, responseError(res)
+	}
+
+	var br BulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&br); err != nil {
+		return nil, err
+	}
+
+	if len(br.Items) != len(ops) {
+		return nil, fmt.Errorf("expected %d bulk items, got %d", len(ops), len(br.Items))
+	}
+
+	results := []*model.BulkResult{}
+
+	for i, op := range ops {
+		results = append(results, mapBulkItemToBulkResult(op.Type, br.Items[i][op.Type]))
+	}
+
+	return results, nil
+}
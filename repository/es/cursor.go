@@ -0,0 +1,43 @@
+package es
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+
+	myerr "github.com/pejovski/catalog/error"
+)
+
+// sortValues is the stable sort of a paginated listing, the _id acts as a tie-breaker
+// between products with the same score so search_after never skips or repeats a hit
+var sortValues = []map[string]string{
+	{"_score": "desc"},
+	{"_id": "asc"},
+}
+
+// encodeCursor makes an opaque cursor from the sort values of the last hit of a page
+func encodeCursor(sort []interface{}) (string, error) {
+	b, err := json.Marshal(sort)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// decodeCursor returns the search_after values of the cursor
+func decodeCursor(cursor string) ([]interface{}, error) {
+	b, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return nil, myerr.ErrInvalidCursor
+	}
+
+	var sort []interface{}
+
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	if err := d.Decode(&sort); err != nil || len(sort) != len(sortValues) {
+		return nil, myerr.ErrInvalidCursor
+	}
+
+	return sort, nil
+}
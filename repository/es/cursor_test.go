@@ -0,0 +1,31 @@
+package es
+
+import (
+	"encoding/json"
+	"testing"
+
+	myerr "github.com/pejovski/catalog/error"
+)
+
+func TestCursor(t *testing.T) {
+
+	c, err := encodeCursor([]interface{}{1.5, "1RJg9nXDy2nP2mWOxlvVXbCXoSd"})
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	sort, err := decodeCursor(c)
+	if err != nil {
+		t.Fatalf("Expected no error, got %s", err)
+	}
+
+	if sort[0] != json.Number("1.5") || sort[1] != "1RJg9nXDy2nP2mWOxlvVXbCXoSd" {
+		t.Errorf("Expected decoded cursor to match, got %v", sort)
+	}
+
+	for _, c := range []string{"not base64!", "e30", "WyJhIl0"} {
+		if _, err := decodeCursor(c); err != myerr.ErrInvalidCursor {
+			t.Errorf("Expected ErrInvalidCursor for %s, got %v", c, err)
+		}
+	}
+}
@@ -0,0 +1,138 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/sirupsen/logrus"
+
+	repo "github.com/pejovski/catalog/repository"
+)
+
+const dedupIndex = "dedup"
+
+const dedupDefinition = `{
+  "settings": {
+    "number_of_shards": 1,
+    "number_of_replicas": 1
+  },
+  "mappings": {
+    "dynamic": "strict",
+    "properties": {
+      "marked_at": { "type": "long" }
+    }
+  }
+}`
+
+// SetupDedup creates the dedup index if missing and adds the fields it lacks
+func SetupDedup(client *elasticsearch.Client) error {
+	if err := createIndex(client, dedupIndex, dedupDefinition); err != nil {
+		return err
+	}
+	return putMapping(client, dedupIndex, dedupDefinition)
+}
+
+type dedup struct {
+	client   *elasticsearch.Client
+	timeouts Timeouts
+	ttl      time.Duration
+}
+
+// NewDedup keeps a document per message, the messages older than the ttl are deleted by Purge
+func NewDedup(es *elasticsearch.Client, t Timeouts, ttl time.Duration) repo.Dedup {
+	return dedup{client: es, timeouts: t, ttl: ttl}
+}
+
+// Seen gets the document by id, which unlike a search is real-time
+func (d dedup) Seen(ctx context.Context, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, d.timeouts.Read)
+	defer cancel()
+
+	res, err := d.client.Get(dedupIndex, id, d.client.Get.WithContext(ctx))
+	if err != nil {
+		logrus.Errorf("Failed to get message %s", id)
+		return false, requestError(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	if res.IsError() {
+		logrus.Errorf("Error in the response for message %s. Status code: %d. Response: %s", id, res.StatusCode, res.String())
+		return false, responseError(res)
+	}
+
+	var h struct {
+		Source DedupDocument `json:"_source"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&h); err != nil {
+		logrus.Errorf("Failed to decode body for message %s", id)
+		return false, err
+	}
+
+	return time.Since(time.Unix(0, h.Source.MarkedAt)) < d.ttl, nil
+}
+
+func (d dedup) Mark(ctx context.Context, id string) error {
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(DedupDocument{MarkedAt: time.Now().UnixNano()}); err != nil {
+		logrus.Errorf("Failed to encode message %s", id)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeouts.Write)
+	defer cancel()
+
+	res, err := d.client.Index(dedupIndex, &buf, d.client.Index.WithDocumentID(id), d.client.Index.WithContext(ctx))
+	if err != nil {
+		logrus.Errorf("Failed to mark message %s", id)
+		return requestError(err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		logrus.Errorf("Error in the response for message %s. Status code: %d. Response: %s", id, res.StatusCode, res.String())
+		return responseError(res)
+	}
+
+	return nil
+}
+
+func (d dedup) Purge(ctx context.Context) error {
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"range": map[string]interface{}{
+				"marked_at": map[string]interface{}{"lt": time.Now().Add(-d.ttl).UnixNano()},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		logrus.Errorf("Failed to encode dedup purge query %v", query)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, d.timeouts.Bulk)
+	defer cancel()
+
+	res, err := d.client.DeleteByQuery([]string{dedupIndex}, &buf, d.client.DeleteByQuery.WithContext(ctx))
+	if err != nil {
+		logrus.Errorf("Failed to purge expired messages")
+		return requestError(err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		logrus.Errorf("Error in the dedup purge response. Status code: %d. Response: %s", res.StatusCode, res.String())
+		return responseError(res)
+	}
+
+	return nil
+}
@@ -6,6 +6,16 @@ type Document struct {
 	Price    float32 `json:"price"`
 	Category string  `json:"category"`
 	Image    string  `json:"image"`
+	// rating is maintained only by UpdateRating, it is omitted on create and update
+	// so that a partial update of the other fields does not wipe it
+	Rating *Rating `json:"rating,omitempty"`
+}
+
+type Rating struct {
+	// out of 5 (e.g. 3.9)
+	Stars float32 `json:"stars"`
+	// number of customers who reviewed the product
+	Customers int `json:"customers"`
 }
 
 type Update struct {
@@ -13,12 +23,99 @@ type Update struct {
 }
 
 type Hit struct {
-	Id     string   `json:"_id"`
-	Source Document `json:"_source"`
+	Id          string              `json:"_id"`
+	SeqNo       *int                `json:"_seq_no,omitempty"`
+	PrimaryTerm *int                `json:"_primary_term,omitempty"`
+	Source      Document            `json:"_source"`
+	Highlight   map[string][]string `json:"highlight,omitempty"`
+	Sort        []interface{}       `json:"sort,omitempty"`
 }
 
 type Result struct {
 	Hits struct {
+		Total struct {
+			Value int `json:"value"`
+		} `json:"total"`
 		Hits []Hit `json:"hits"`
 	} `json:"hits"`
+	Aggregations map[string]Aggregation `json:"aggregations"`
+}
+
+// Aggregation is a facet, the buckets are nested in a filter aggregation
+type Aggregation struct {
+	Values struct {
+		Buckets []Bucket `json:"buckets"`
+	} `json:"values"`
+}
+
+type Bucket struct {
+	Key      string `json:"key"`
+	DocCount int    `json:"doc_count"`
+}
+
+type BulkAction struct {
+	Index string `json:"_index"`
+	Id    string `json:"_id"`
+	// the update or delete is conditional on the version when set
+	IfSeqNo       *int `json:"if_seq_no,omitempty"`
+	IfPrimaryTerm *int `json:"if_primary_term,omitempty"`
+}
+
+type Mget struct {
+	Ids []string `json:"ids"`
+}
+
+type MgetResponse struct {
+	Docs []MgetDoc `json:"docs"`
+}
+
+type MgetDoc struct {
+	Hit
+	Found bool `json:"found"`
+}
+
+type BulkResponse struct {
+	Errors bool `json:"errors"`
+	// each item is keyed by the action type
+	Items []map[string]BulkItem `json:"items"`
+}
+
+type BulkItem struct {
+	Id     string `json:"_id"`
+	Status int    `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+type OutboxDocument struct {
+	Type      string `json:"type"`
+	ProductId string `json:"product_id"`
+	// snapshots are stored but not indexed
+	Before *Document `json:"before,omitempty"`
+	After  *Document `json:"after,omitempty"`
+	// pending, ready, sent or parked
+	Status   string `json:"status"`
+	Attempts int    `json:"attempts"`
+	// reason of the last failed attempt
+	Error string `json:"error,omitempty"`
+	// unix nanoseconds, milliseconds are too coarse to keep the order of events
+	CreatedAt int64 `json:"created_at"`
+	// when the event was sent or parked
+	SentAt int64 `json:"sent_at,omitempty"`
+}
+
+type OutboxResult struct {
+	Hits struct {
+		Hits []struct {
+			Id     string         `json:"_id"`
+			Source OutboxDocument `json:"_source"`
+		} `json:"hits"`
+	} `json:"hits"`
+}
+
+type DedupDocument struct {
+	// unix nanoseconds
+	MarkedAt int64 `json:"marked_at"`
 }
@@ -0,0 +1,60 @@
+package es
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+
+	myerr "github.com/pejovski/catalog/error"
+)
+
+// requestError classifies an error of a request that got no response
+func requestError(err error) error {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return myerr.Wrap(myerr.ErrTimeout, err)
+	}
+	if errors.Is(err, context.Canceled) {
+		return err
+	}
+	return myerr.Wrap(myerr.ErrUnavailable, err)
+}
+
+// responseError maps an elasticsearch error response to an error of the matching kind,
+// keeping the status code and the reason given by elasticsearch. A bad request is a bug of the
+// query or the mapping rather than of the client input, so it is an internal error.
+func responseError(res *esapi.Response) error {
+	var body struct {
+		Error struct {
+			Type   string `json:"type"`
+			Reason string `json:"reason"`
+		} `json:"error"`
+	}
+	// the body is not always an error object (e.g. a missing document), the reason is then empty
+	_ = json.NewDecoder(res.Body).Decode(&body)
+
+	reason := body.Error.Reason
+	if body.Error.Type != "" {
+		reason = fmt.Sprintf("%s: %s", body.Error.Type, reason)
+	}
+
+	e := &myerr.Error{Status: res.StatusCode, Reason: reason}
+
+	switch res.StatusCode {
+	case http.StatusNotFound:
+		e.Kind = myerr.ErrNotFound
+	case http.StatusConflict:
+		e.Kind = myerr.ErrConflict
+	case http.StatusRequestTimeout, http.StatusGatewayTimeout:
+		e.Kind = myerr.ErrTimeout
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable:
+		e.Kind = myerr.ErrUnavailable
+	default:
+		e.Kind = myerr.ErrInternal
+	}
+
+	return e
+}
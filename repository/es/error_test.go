@@ -0,0 +1,45 @@
+package es
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+
+	myerr "github.com/pejovski/catalog/error"
+)
+
+func TestResponseError(t *testing.T) {
+	tests := []struct {
+		status int
+		kind   error
+	}{
+		{http.StatusNotFound, myerr.ErrNotFound},
+		{http.StatusConflict, myerr.ErrConflict},
+		{http.StatusServiceUnavailable, myerr.ErrUnavailable},
+		// a malformed query is not the fault of the client
+		{http.StatusBadRequest, myerr.ErrInternal},
+		{http.StatusInternalServerError, myerr.ErrInternal},
+	}
+
+	for _, tt := range tests {
+		res := &esapi.Response{
+			StatusCode: tt.status,
+			Body:       ioutil.NopCloser(strings.NewReader(`{"error":{"type":"parsing_exception","reason":"unknown query"}}`)),
+		}
+
+		err := responseError(res)
+
+		if !errors.Is(err, tt.kind) {
+			t.Errorf("Expected %s for status %d, got %s", tt.kind, tt.status, err)
+		}
+
+		var e *myerr.Error
+		if !errors.As(err, &e) || e.Status != tt.status || e.Reason != "parsing_exception: unknown query" {
+			t.Errorf("Expected the status %d and the reason to be kept, got %+v", tt.status, err)
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package es
+
+import (
+	"github.com/pejovski/catalog/model"
+)
+
+const (
+	facetCategories = "categories"
+	facetBrands     = "brands"
+	facetPrices     = "prices"
+	facetRatings    = "ratings"
+
+	fieldCategory = "category"
+	fieldBrand    = "brand.keyword"
+	fieldPrice    = "price"
+	fieldRating   = "rating.stars"
+
+	// number of brand and category buckets returned
+	facetSize = 20
+)
+
+var facetNames = []string{facetCategories, facetBrands, facetPrices, facetRatings}
+
+// filterClauses returns one clause per facet that has selected values
+func filterClauses(f model.Filter) map[string]interface{} {
+	clauses := map[string]interface{}{}
+
+	if len(f.Categories) > 0 {
+		clauses[facetCategories] = termsClause(fieldCategory, f.Categories)
+	}
+	if len(f.Brands) > 0 {
+		clauses[facetBrands] = termsClause(fieldBrand, f.Brands)
+	}
+	if len(f.Prices) > 0 {
+		clauses[facetPrices] = rangesClause(fieldPrice, model.PriceRanges, f.Prices)
+	}
+	if len(f.Ratings) > 0 {
+		clauses[facetRatings] = rangesClause(fieldRating, model.RatingRanges, f.Ratings)
+	}
+
+	return clauses
+}
+
+func termsClause(field string, values []string) map[string]interface{} {
+	return map[string]interface{}{
+		"terms": map[string]interface{}{
+			field: values,
+		},
+	}
+}
+
+func rangesClause(field string, ranges []model.Range, keys []string) map[string]interface{} {
+	should := []interface{}{}
+
+	for _, k := range keys {
+		if r, ok := model.FindRange(ranges, k); ok {
+			should = append(should, map[string]interface{}{
+				"range": map[string]interface{}{
+					field: rangeBounds(r),
+				},
+			})
+		}
+	}
+
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"should":               should,
+			"minimum_should_match": 1,
+		},
+	}
+}
+
+func rangeBounds(r model.Range) map[string]interface{} {
+	b := map[string]interface{}{"gte": r.From}
+	if r.To > 0 {
+		b["lt"] = r.To
+	}
+	return b
+}
+
+// postFilter applies the clauses of all facets except the excluded one,
+// an empty exclude applies them all
+func postFilter(clauses map[string]interface{}, exclude string) map[string]interface{} {
+	filter := []interface{}{}
+
+	for _, name := range facetNames {
+		if c, ok := clauses[name]; ok && name != exclude {
+			filter = append(filter, c)
+		}
+	}
+
+	return map[string]interface{}{
+		"bool": map[string]interface{}{
+			"filter": filter,
+		},
+	}
+}
+
+// aggregations counts every facet within a filter of the other facets' selections
+// so that selecting a value does not hide the alternatives of the same facet
+func aggregations(clauses map[string]interface{}) map[string]interface{} {
+	values := map[string]interface{}{
+		facetCategories: map[string]interface{}{
+			"terms": map[string]interface{}{"field": fieldCategory, "size": facetSize},
+		},
+		facetBrands: map[string]interface{}{
+			"terms": map[string]interface{}{"field": fieldBrand, "size": facetSize},
+		},
+		facetPrices: map[string]interface{}{
+			"range": map[string]interface{}{"field": fieldPrice, "ranges": rangeAggregation(model.PriceRanges)},
+		},
+		facetRatings: map[string]interface{}{
+			"range": map[string]interface{}{"field": fieldRating, "ranges": rangeAggregation(model.RatingRanges)},
+		},
+	}
+
+	aggs := map[string]interface{}{}
+
+	for _, name := range facetNames {
+		aggs[name] = map[string]interface{}{
+			"filter": postFilter(clauses, name),
+			"aggs": map[string]interface{}{
+				"values": values[name],
+			},
+		}
+	}
+
+	return aggs
+}
+
+func rangeAggregation(ranges []model.Range) []interface{} {
+	rs := []interface{}{}
+
+	for _, r := range ranges {
+		a := map[string]interface{}{"key": r.Key, "from": r.From}
+		if r.To > 0 {
+			a["to"] = r.To
+		}
+		rs = append(rs, a)
+	}
+
+	return rs
+}
+
+// withFacets adds the post filter and the facet aggregations to the search body
+func withFacets(query map[string]interface{}, f model.Filter) {
+	clauses := filterClauses(f)
+
+	query["post_filter"] = postFilter(clauses, "")
+	query["aggs"] = aggregations(clauses)
+}
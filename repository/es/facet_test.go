@@ -0,0 +1,45 @@
+package es
+
+import (
+	"testing"
+
+	"github.com/pejovski/catalog/model"
+)
+
+func TestAggregationsExcludeOwnFacet(t *testing.T) {
+
+	f := model.Filter{Brands: []string{"Samsung"}, Prices: []string{"100-500"}}
+
+	aggs := aggregations(filterClauses(f))
+
+	count := func(facet string) int {
+		filter := aggs[facet].(map[string]interface{})["filter"].(map[string]interface{})
+		return len(filter["bool"].(map[string]interface{})["filter"].([]interface{}))
+	}
+
+	if count(facetBrands) != 1 || count(facetPrices) != 1 {
+		t.Error("Expected brand and price facets to be filtered only by the other facet")
+	}
+
+	if count(facetCategories) != 2 || count(facetRatings) != 2 {
+		t.Error("Expected category and rating facets to be filtered by both selections")
+	}
+}
+
+func TestMapAggregationsToFacets(t *testing.T) {
+
+	aggs := map[string]Aggregation{}
+	a := Aggregation{}
+	a.Values.Buckets = []Bucket{{Key: "Samsung", DocCount: 3}, {Key: "Apple", DocCount: 2}}
+	aggs[facetBrands] = a
+
+	fs := mapAggregationsToFacets(aggs, model.Filter{Brands: []string{"Apple"}})
+
+	if len(fs.Brands) != 2 || fs.Brands[0].Selected || !fs.Brands[1].Selected {
+		t.Errorf("Expected only Apple to be selected, got %+v %+v", fs.Brands[0], fs.Brands[1])
+	}
+
+	if len(fs.Categories) != 0 {
+		t.Error("Expected no category buckets")
+	}
+}
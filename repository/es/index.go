@@ -0,0 +1,294 @@
+package es
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/sirupsen/logrus"
+)
+
+// indexVersion must be increased on every mapping or settings change,
+// on startup the documents are reindexed into the new index and the alias is flipped
+const indexVersion = 1
+
+// indexDefinition is owned by the service, the mapping is strict so a field
+// added to Document without a mapping change fails loudly instead of being guessed
+const indexDefinition = `{
+  "settings": {
+    "number_of_shards": 1,
+    "number_of_replicas": 1
+  },
+  "mappings": {
+    "dynamic": "strict",
+    "properties": {
+      "name": {
+        "type": "text",
+        "fields": {
+          "keyword": { "type": "keyword" }
+        }
+      },
+      "brand": {
+        "type": "text",
+        "fields": {
+          "keyword": { "type": "keyword" }
+        }
+      },
+      "price": { "type": "scaled_float", "scaling_factor": 100 },
+      "category": { "type": "keyword" },
+      "image": { "type": "keyword", "index": false },
+      "rating": {
+        "properties": {
+          "stars": { "type": "float" },
+          "customers": { "type": "integer" }
+        }
+      }
+    }
+  }
+}`
+
+func versionedIndex(version int) string {
+	return fmt.Sprintf("%s_v%d", index, version)
+}
+
+// newestVersion returns the highest version of the indices, 0 when none of them is versioned
+func newestVersion(indices []string) int {
+	newest := 0
+	for _, i := range indices {
+		v, err := strconv.Atoi(strings.TrimPrefix(i, index+"_v"))
+		if err != nil || !strings.HasPrefix(i, index+"_v") {
+			continue
+		}
+		if v > newest {
+			newest = v
+		}
+	}
+	return newest
+}
+
+// SetupIndex makes sure the products alias points to the index of the current version.
+// The index is created if missing and, when the alias points to an older version or
+// products is still a plain index, the documents are reindexed and the alias is flipped
+// in a single atomic request so readers never see a missing or half filled index.
+// An alias pointing to a newer version is left alone, e.g. for the old instances of a rolling deploy.
+func SetupIndex(client *elasticsearch.Client) error {
+	target := versionedIndex(indexVersion)
+
+	current, err := aliasedIndices(client)
+	if err != nil {
+		return err
+	}
+
+	if newestVersion(current) > indexVersion {
+		logrus.Warnf("Elasticsearch alias %s points to %v, newer than %s, leaving it", index, current, target)
+		return nil
+	}
+
+	if len(current) == 1 && current[0] == target {
+		logrus.Infof("Elasticsearch alias %s points to %s", index, target)
+		return nil
+	}
+
+	// before versioning products was a plain index, it is replaced by the alias
+	legacy := false
+	if len(current) == 0 {
+		if legacy, err = indexExists(client, index); err != nil {
+			return err
+		}
+	}
+
+	migrate := len(current) > 0 || legacy
+
+	// reindexing into a leftover of a failed or concurrent migration would mix its documents in
+	if migrate {
+		exists, err := indexExists(client, target)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return fmt.Errorf("index %s exists but alias %s does not point to it, delete it to migrate again", target, index)
+		}
+	}
+
+	if err = createIndex(client, target, indexDefinition); err != nil {
+		return err
+	}
+
+	if migrate {
+		if err = reindex(client, index, target); err != nil {
+			return err
+		}
+	}
+
+	return flipAlias(client, current, target, legacy)
+}
+
+func aliasedIndices(client *elasticsearch.Client) ([]string, error) {
+	res, err := client.Indices.GetAlias(client.Indices.GetAlias.WithName(index))
+	if err != nil {
+		logrus.Errorf("Failed to get alias %s", index)
+		return nil, requestError(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+
+	if res.IsError() {
+		logrus.Errorf("Error in the response for alias %s. Status code: %d. Response: %s", index, res.StatusCode, res.String())
+		return nil, responseError(res)
+	}
+
+	var aliases map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&aliases); err != nil {
+		logrus.Errorf("Failed to decode alias %s", index)
+		return nil, err
+	}
+
+	indices := []string{}
+	for i := range aliases {
+		indices = append(indices, i)
+	}
+
+	return indices, nil
+}
+
+func indexExists(client *elasticsearch.Client, name string) (bool, error) {
+	res, err := client.Indices.Exists([]string{name})
+	if err != nil {
+		logrus.Errorf("Failed to check index %s", name)
+		return false, requestError(err)
+	}
+	defer res.Body.Close()
+
+	return res.StatusCode == http.StatusOK, nil
+}
+
+func createIndex(client *elasticsearch.Client, name string, definition string) error {
+	exists, err := indexExists(client, name)
+	if err != nil || exists {
+		return err
+	}
+
+	res, err := client.Indices.Create(name, client.Indices.Create.WithBody(strings.NewReader(definition)))
+	if err != nil {
+		logrus.Errorf("Failed to create index %s", name)
+		return requestError(err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		logrus.Errorf("Error in the response for index %s. Status code: %d. Response: %s", name, res.StatusCode, res.String())
+		return responseError(res)
+	}
+
+	logrus.Infof("Elasticsearch index %s created", name)
+
+	return nil
+}
+
+// putMapping applies the mappings of the definition to an existing index, it only succeeds for
+// additive changes like a new field, anything else needs a new index
+func putMapping(client *elasticsearch.Client, name string, definition string) error {
+	var d struct {
+		Mappings json.RawMessage `json:"mappings"`
+	}
+	if err := json.Unmarshal([]byte(definition), &d); err != nil {
+		logrus.Errorf("Failed to decode definition of index %s", name)
+		return err
+	}
+
+	res, err := client.Indices.PutMapping(bytes.NewReader(d.Mappings), client.Indices.PutMapping.WithIndex(name))
+	if err != nil {
+		logrus.Errorf("Failed to put mapping of index %s", name)
+		return requestError(err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		logrus.Errorf("Error in the mapping response for index %s. Status code: %d. Response: %s", name, res.StatusCode, res.String())
+		return responseError(res)
+	}
+
+	return nil
+}
+
+func reindex(client *elasticsearch.Client, source, dest string) error {
+	body := map[string]interface{}{
+		"source": map[string]interface{}{"index": source},
+		"dest":   map[string]interface{}{"index": dest},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(body); err != nil {
+		logrus.Errorf("Failed to encode reindex from %s to %s", source, dest)
+		return err
+	}
+
+	res, err := client.Reindex(
+		&buf,
+		client.Reindex.WithWaitForCompletion(true),
+		client.Reindex.WithRefresh(true),
+	)
+	if err != nil {
+		logrus.Errorf("Failed to reindex from %s to %s", source, dest)
+		return requestError(err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		logrus.Errorf("Error in the reindex response from %s to %s. Status code: %d. Response: %s", source, dest, res.StatusCode, res.String())
+		return responseError(res)
+	}
+
+	logrus.Infof("Elasticsearch index %s reindexed to %s", source, dest)
+
+	return nil
+}
+
+func flipAlias(client *elasticsearch.Client, current []string, target string, legacy bool) error {
+	actions := []interface{}{}
+
+	if legacy {
+		actions = append(actions, map[string]interface{}{
+			"remove_index": map[string]interface{}{"index": index},
+		})
+	}
+
+	for _, i := range current {
+		actions = append(actions, map[string]interface{}{
+			"remove": map[string]interface{}{"index": i, "alias": index},
+		})
+	}
+
+	actions = append(actions, map[string]interface{}{
+		"add": map[string]interface{}{"index": target, "alias": index},
+	})
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(map[string]interface{}{"actions": actions}); err != nil {
+		logrus.Errorf("Failed to encode alias actions for %s", target)
+		return err
+	}
+
+	res, err := client.Indices.UpdateAliases(&buf)
+	if err != nil {
+		logrus.Errorf("Failed to point alias %s to %s", index, target)
+		return requestError(err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		logrus.Errorf("Error in the response for alias %s. Status code: %d. Response: %s", index, res.StatusCode, res.String())
+		return responseError(res)
+	}
+
+	logrus.Infof("Elasticsearch alias %s points to %s", index, target)
+
+	return nil
+}
@@ -0,0 +1,50 @@
+package es
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestIndexDefinitionMapsDocument(t *testing.T) {
+
+	var def struct {
+		Mappings struct {
+			Properties map[string]interface{} `json:"properties"`
+		} `json:"mappings"`
+	}
+
+	if err := json.Unmarshal([]byte(indexDefinition), &def); err != nil {
+		t.Fatalf("Expected valid index definition, got %s", err)
+	}
+
+	// the mapping is strict, every document field must be mapped
+	dt := reflect.TypeOf(Document{})
+	for i := 0; i < dt.NumField(); i++ {
+		name := strings.Split(dt.Field(i).Tag.Get("json"), ",")[0]
+		if _, ok := def.Mappings.Properties[name]; !ok {
+			t.Errorf("Expected field %s to be mapped", name)
+		}
+	}
+}
+
+func TestNewestVersion(t *testing.T) {
+	tests := []struct {
+		indices []string
+		version int
+	}{
+		{nil, 0},
+		// a plain products index is not versioned
+		{[]string{index}, 0},
+		{[]string{versionedIndex(1)}, 1},
+		{[]string{versionedIndex(2), versionedIndex(10)}, 10},
+		{[]string{"products_vx", "other_v3"}, 0},
+	}
+
+	for _, tt := range tests {
+		if v := newestVersion(tt.indices); v != tt.version {
+			t.Errorf("Expected version %d of %v, got %d", tt.version, tt.indices, v)
+		}
+	}
+}
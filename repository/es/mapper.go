@@ -1,14 +1,107 @@
 package es
 
 import (
+	"fmt"
+	"net/http"
+	"time"
+
 	"github.com/pejovski/catalog/model"
 )
 
 func mapHitToProduct(h *Hit) *model.Product {
 	s := h.Source
-	return &model.Product{Id: h.Id, Name: s.Name, Brand: s.Brand, Price: s.Price, Category: s.Category, Image: s.Image}
+	p := &model.Product{Id: h.Id, Name: s.Name, Brand: s.Brand, Price: s.Price, Category: s.Category, Image: s.Image}
+	if s.Rating != nil {
+		p.Rating = model.Rating{Stars: s.Rating.Stars, Customers: s.Rating.Customers}
+	}
+	if h.SeqNo != nil && h.PrimaryTerm != nil {
+		p.Version = &model.Version{SeqNo: *h.SeqNo, PrimaryTerm: *h.PrimaryTerm}
+	}
+	return p
+}
+
+func mapHitToSearchHit(h *Hit) *model.SearchHit {
+	return &model.SearchHit{Product: mapHitToProduct(h), Highlights: h.Highlight}
 }
 
 func mapProductToDocument(p *model.Product) *Document {
 	return &Document{Name: p.Name, Brand: p.Brand, Price: p.Price, Category: p.Category, Image: p.Image}
 }
+
+func mapRatingToDocumentRating(r *model.Rating) *Rating {
+	return &Rating{Stars: r.Stars, Customers: r.Customers}
+}
+
+func mapAggregationsToFacets(aggs map[string]Aggregation, f model.Filter) *model.Facets {
+	return &model.Facets{
+		Categories: mapBucketsToDomainBuckets(aggs[facetCategories].Values.Buckets, f.Categories),
+		Brands:     mapBucketsToDomainBuckets(aggs[facetBrands].Values.Buckets, f.Brands),
+		Prices:     mapBucketsToDomainBuckets(aggs[facetPrices].Values.Buckets, f.Prices),
+		Ratings:    mapBucketsToDomainBuckets(aggs[facetRatings].Values.Buckets, f.Ratings),
+	}
+}
+
+func mapBucketsToDomainBuckets(bs []Bucket, selected []string) []*model.Bucket {
+	dbs := []*model.Bucket{}
+	for _, b := range bs {
+		db := &model.Bucket{Key: b.Key, Count: b.DocCount}
+		for _, s := range selected {
+			if s == b.Key {
+				db.Selected = true
+			}
+		}
+		dbs = append(dbs, db)
+	}
+	return dbs
+}
+
+func mapBulkItemToBulkResult(t string, i BulkItem) *model.BulkResult {
+	r := &model.BulkResult{Type: t, Id: i.Id, Status: i.Status}
+	if i.Error != nil {
+		r.Error = fmt.Sprintf("%s: %s", i.Error.Type, i.Error.Reason)
+	} else if i.Status >= http.StatusMultipleChoices {
+		// e.g. deleting a missing document is a 404 without an error
+		r.Error = http.StatusText(i.Status)
+	}
+	return r
+}
+
+func mapEventToOutboxDocument(e *model.Event) *OutboxDocument {
+	d := &OutboxDocument{
+		Type:      e.Type,
+		ProductId: e.ProductId,
+		Status:    statusPending,
+		Attempts:  e.Attempts,
+		CreatedAt: e.CreatedAt.UnixNano(),
+	}
+	d.Before = mapProductToSnapshot(e.Before)
+	d.After = mapProductToSnapshot(e.After)
+	return d
+}
+
+func mapOutboxDocumentToEvent(id string, d OutboxDocument) *model.Event {
+	e := &model.Event{
+		Id:        id,
+		Type:      d.Type,
+		ProductId: d.ProductId,
+		CreatedAt: time.Unix(0, d.CreatedAt),
+		Attempts:  d.Attempts,
+	}
+	if d.Before != nil {
+		e.Before = mapHitToProduct(&Hit{Id: d.ProductId, Source: *d.Before})
+	}
+	if d.After != nil {
+		e.After = mapHitToProduct(&Hit{Id: d.ProductId, Source: *d.After})
+	}
+	return e
+}
+
+// mapProductToSnapshot keeps the rating unlike mapProductToDocument, nil stays nil
+func mapProductToSnapshot(p *model.Product) *Document {
+	if p == nil {
+		return nil
+	}
+	d := mapProductToDocument(p)
+	d.Rating = mapRatingToDocumentRating(&p.Rating)
+	return d
+}
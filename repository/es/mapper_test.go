@@ -2,6 +2,8 @@ package es
 
 import (
 	"testing"
+
+	"github.com/pejovski/catalog/model"
 )
 
 func TestMapHitToProduct(t *testing.T) {
@@ -23,3 +25,52 @@ func TestMapHitToProduct(t *testing.T) {
 		t.Error("Expected ids to be equal")
 	}
 }
+
+func TestMapHitToProductRating(t *testing.T) {
+
+	hit := &Hit{
+		Id: "111",
+		Source: Document{
+			Name:   "Galaxy",
+			Rating: &Rating{Stars: 4.2, Customers: 17},
+		},
+	}
+
+	p := mapHitToProduct(hit)
+
+	if p.Stars != 4.2 || p.Customers != 17 {
+		t.Errorf("Expected rating 4.2 from 17 customers, got %v from %d", p.Stars, p.Customers)
+	}
+
+	p = mapHitToProduct(&Hit{Id: "222"})
+
+	if p.Stars != 0 || p.Customers != 0 {
+		t.Error("Expected empty rating for a document without rating")
+	}
+}
+
+func TestMapProductToDocumentOmitsRating(t *testing.T) {
+
+	p := &model.Product{Id: "111", Name: "Galaxy", Rating: model.Rating{Stars: 4.2, Customers: 17}}
+
+	d := mapProductToDocument(p)
+
+	if d.Rating != nil {
+		t.Error("Expected rating to be omitted so updates do not overwrite it")
+	}
+}
+
+func TestMapBulkItemToBulkResult(t *testing.T) {
+
+	r := mapBulkItemToBulkResult(model.OpDelete, BulkItem{Id: "111", Status: 404})
+
+	if r.Error == "" {
+		t.Error("Expected deleting a missing product to be reported as failed")
+	}
+
+	r = mapBulkItemToBulkResult(model.OpCreate, BulkItem{Id: "222", Status: 201})
+
+	if r.Error != "" || r.Id != "222" {
+		t.Errorf("Expected successful create of 222, got %+v", r)
+	}
+}
@@ -0,0 +1,311 @@
+package es
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+	"github.com/sirupsen/logrus"
+
+	"github.com/pejovski/catalog/model"
+	repo "github.com/pejovski/catalog/repository"
+)
+
+const outboxIndex = "outbox"
+
+const (
+	statusPending = "pending"
+	statusReady   = "ready"
+	statusSent    = "sent"
+	statusParked  = "parked"
+)
+
+const outboxDefinition = `{
+  "settings": {
+    "number_of_shards": 1,
+    "number_of_replicas": 1
+  },
+  "mappings": {
+    "dynamic": "strict",
+    "properties": {
+      "type": { "type": "keyword" },
+      "product_id": { "type": "keyword" },
+      "before": { "type": "object", "enabled": false },
+      "after": { "type": "object", "enabled": false },
+      "status": { "type": "keyword" },
+      "attempts": { "type": "integer" },
+      "error": { "type": "text", "index": false },
+      "created_at": { "type": "long" },
+      "sent_at": { "type": "long" }
+    }
+  }
+}`
+
+// SetupOutbox creates the outbox index if missing and adds the fields it lacks,
+// unlike products the outbox is not versioned so its mapping may only be extended
+func SetupOutbox(client *elasticsearch.Client) error {
+	if err := createIndex(client, outboxIndex, outboxDefinition); err != nil {
+		return err
+	}
+	return putMapping(client, outboxIndex, outboxDefinition)
+}
+
+type outbox struct {
+	client   *elasticsearch.Client
+	timeouts Timeouts
+}
+
+func NewOutbox(es *elasticsearch.Client, t Timeouts) repo.Outbox {
+	return outbox{client: es, timeouts: t}
+}
+
+func (o outbox) Add(ctx context.Context, events ...*model.Event) error {
+	lines := []interface{}{}
+	for _, e := range events {
+		lines = append(lines,
+			map[string]BulkAction{"create": {Index: outboxIndex, Id: e.Id}},
+			mapEventToOutboxDocument(e),
+		)
+	}
+
+	return o.bulk(ctx, lines, false)
+}
+
+func (o outbox) Ready(ctx context.Context, ids ...string) error {
+	return o.bulk(ctx, o.updates(ids, map[string]interface{}{"status": statusReady}), false)
+}
+
+func (o outbox) Remove(ctx context.Context, ids ...string) error {
+	lines := []interface{}{}
+	for _, id := range ids {
+		lines = append(lines, map[string]BulkAction{"delete": {Index: outboxIndex, Id: id}})
+	}
+
+	return o.bulk(ctx, lines, false)
+}
+
+// Sent waits for the refresh, otherwise the next Unsent could return the events again
+func (o outbox) Sent(ctx context.Context, ids ...string) error {
+	doc := map[string]interface{}{"status": statusSent, "sent_at": time.Now().UnixNano()}
+	return o.bulk(ctx, o.updates(ids, doc), true)
+}
+
+func (o outbox) updates(ids []string, doc map[string]interface{}) []interface{} {
+	lines := []interface{}{}
+	for _, id := range ids {
+		lines = append(lines,
+			map[string]BulkAction{"update": {Index: outboxIndex, Id: id}},
+			map[string]interface{}{"doc": doc},
+		)
+	}
+	return lines
+}
+
+func (o outbox) Unsent(ctx context.Context, pendingBefore time.Time, limit int) ([]*model.Event, error) {
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"should": []interface{}{
+					map[string]interface{}{"term": map[string]interface{}{"status": statusReady}},
+					map[string]interface{}{"bool": map[string]interface{}{
+						"filter": []interface{}{
+							map[string]interface{}{"term": map[string]interface{}{"status": statusPending}},
+							map[string]interface{}{"range": map[string]interface{}{
+								"created_at": map[string]interface{}{"lt": pendingBefore.UnixNano()},
+							}},
+						},
+					}},
+				},
+				"minimum_should_match": 1,
+			},
+		},
+		"size": limit,
+		"sort": []interface{}{
+			map[string]interface{}{"created_at": "asc"},
+			map[string]interface{}{"_id": "asc"},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		logrus.Errorf("Failed to encode outbox query %v", query)
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.timeouts.Search)
+	defer cancel()
+
+	res, err := o.client.Search(
+		o.client.Search.WithContext(ctx),
+		o.client.Search.WithIndex(outboxIndex),
+		o.client.Search.WithBody(&buf),
+	)
+	if err != nil {
+		logrus.Errorf("Failed to get unsent events")
+		return nil, requestError(err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		logrus.Errorf("Error in the outbox search response. Status code: %d. Response: %s", res.StatusCode, res.String())
+		return nil, responseError(res)
+	}
+
+	var result OutboxResult
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		logrus.Errorf("Failed to decode outbox search result")
+		return nil, err
+	}
+
+	events := []*model.Event{}
+	for _, h := range result.Hits.Hits {
+		events = append(events, mapOutboxDocumentToEvent(h.Id, h.Source))
+	}
+
+	return events, nil
+}
+
+func (o outbox) Failed(ctx context.Context, id string, reason error) error {
+	return o.update(ctx, id, "ctx._source.attempts += 1; ctx._source.error = params.error", map[string]interface{}{"error": reason.Error()}, false)
+}
+
+// update runs the script on the event, like Sent it waits for the refresh when the event leaves the unsent ones
+func (o outbox) update(ctx context.Context, id string, script string, params map[string]interface{}, refresh bool) error {
+	up := map[string]interface{}{
+		"script": map[string]interface{}{
+			"source": script,
+			"params": params,
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(up); err != nil {
+		logrus.Errorf("Failed to encode the update of event %s", id)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.timeouts.Write)
+	defer cancel()
+
+	opts := []func(*esapi.UpdateRequest){o.client.Update.WithContext(ctx)}
+	if refresh {
+		opts = append(opts, o.client.Update.WithRefresh("wait_for"))
+	}
+
+	res, err := o.client.Update(outboxIndex, id, &buf, opts...)
+	if err != nil {
+		logrus.Errorf("Failed to update event %s", id)
+		return requestError(err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		logrus.Errorf("Error in the response for event %s. Status code: %d. Response: %s", id, res.StatusCode, res.String())
+		return responseError(res)
+	}
+
+	return nil
+}
+
+func (o outbox) Park(ctx context.Context, id string, reason error) error {
+	return o.update(ctx, id, "ctx._source.attempts += 1; ctx._source.error = params.error; ctx._source.status = params.status; ctx._source.sent_at = params.sent_at",
+		map[string]interface{}{"error": reason.Error(), "status": statusParked, "sent_at": time.Now().UnixNano()}, true)
+}
+
+func (o outbox) Purge(ctx context.Context, sentBefore time.Time) error {
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{"terms": map[string]interface{}{"status": []string{statusSent, statusParked}}},
+					map[string]interface{}{"range": map[string]interface{}{
+						"sent_at": map[string]interface{}{"lt": sentBefore.UnixNano()},
+					}},
+				},
+			},
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(query); err != nil {
+		logrus.Errorf("Failed to encode outbox purge query %v", query)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.timeouts.Bulk)
+	defer cancel()
+
+	res, err := o.client.DeleteByQuery([]string{outboxIndex}, &buf, o.client.DeleteByQuery.WithContext(ctx))
+	if err != nil {
+		logrus.Errorf("Failed to purge sent and parked events")
+		return requestError(err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		logrus.Errorf("Error in the outbox purge response. Status code: %d. Response: %s", res.StatusCode, res.String())
+		return responseError(res)
+	}
+
+	return nil
+}
+
+// bulk sends the lines in a single _bulk request, events missing on update or delete are ignored
+func (o outbox) bulk(ctx context.Context, lines []interface{}, refresh bool) error {
+	if len(lines) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	for _, l := range lines {
+		if err := enc.Encode(l); err != nil {
+			return err
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, o.timeouts.Write)
+	defer cancel()
+
+	opts := []func(*esapi.BulkRequest){o.client.Bulk.WithIndex(outboxIndex), o.client.Bulk.WithContext(ctx)}
+	if refresh {
+		opts = append(opts, o.client.Bulk.WithRefresh("wait_for"))
+	}
+
+	res, err := o.client.Bulk(&buf, opts...)
+	if err != nil {
+		logrus.Errorf("Failed to write the outbox")
+		return requestError(err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		logrus.Errorf("Error in the outbox bulk response. Status code: %d. Response: %s", res.StatusCode, res.String())
+		return responseError(res)
+	}
+
+	var br BulkResponse
+	if err := json.NewDecoder(res.Body).Decode(&br); err != nil {
+		return err
+	}
+
+	for _, item := range br.Items {
+		for action, i := range item {
+			if i.Status < http.StatusMultipleChoices || i.Status == http.StatusNotFound {
+				continue
+			}
+			reason := http.StatusText(i.Status)
+			if i.Error != nil {
+				reason = fmt.Sprintf("%s: %s", i.Error.Type, i.Error.Reason)
+			}
+			return fmt.Errorf("outbox %s of event %s failed (status code: %d): %s", action, i.Id, i.Status, reason)
+		}
+	}
+
+	return nil
+}
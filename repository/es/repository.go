@@ -4,55 +4,116 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
-	"errors"
-	"net/http"
+	"time"
 
 	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
 	"github.com/segmentio/ksuid"
 	"github.com/sirupsen/logrus"
 
-	myerr "github.com/pejovski/catalog/error"
 	"github.com/pejovski/catalog/model"
 	repo "github.com/pejovski/catalog/repository"
 )
 
+// index is the alias of the current versioned index, see SetupIndex
 const index = "products"
 
+// Timeouts bound each kind of elasticsearch call on top of the deadline of the caller's context
+type Timeouts struct {
+	Read   time.Duration
+	Write  time.Duration
+	Search time.Duration
+	// per batch of bulkBatchSize operations
+	Bulk time.Duration
+}
+
+var DefaultTimeouts = Timeouts{
+	Read:   2 * time.Second,
+	Write:  3 * time.Second,
+	Search: 3 * time.Second,
+	Bulk:   10 * time.Second,
+}
+
 type repository struct {
-	client *elasticsearch.Client
+	client   *elasticsearch.Client
+	timeouts Timeouts
 }
 
-func NewRepository(es *elasticsearch.Client) repo.Repository {
-	return repository{client: es}
+func NewRepository(es *elasticsearch.Client, t Timeouts) repo.Repository {
+	return repository{client: es, timeouts: t}
 }
 
-func (r repository) Get(id string) (*model.Product, error) {
+func (r repository) Get(ctx context.Context, id string) (*model.Product, error) {
 	var h *Hit
 
-	res, err := r.client.Get(index, id)
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	res, err := r.client.Get(index, id, r.client.Get.WithContext(ctx))
 	if err != nil {
 		logrus.Errorf("Failed to get product %s", id)
-		return nil, err
+		return nil, requestError(err)
 	}
+	defer res.Body.Close()
 
 	if res.IsError() {
-		if res.StatusCode == http.StatusNotFound {
-			return nil, myerr.ErrNotFound
-		}
 		logrus.Errorf("Error in the response for product with id: %s. Status code: %d. Response: %s", id, res.StatusCode, res.String())
-		return nil, errors.New("response error")
+		return nil, responseError(res)
 	}
 
 	if err := json.NewDecoder(res.Body).Decode(&h); err != nil {
 		logrus.Errorf("Failed to decode body for product %s", id)
 		return nil, err
 	}
-	defer res.Body.Close()
 
 	return mapHitToProduct(h), nil
 }
 
-func (r repository) Create(p *model.Product) (id string, err error) {
+func (r repository) GetByIds(ctx context.Context, ids []string) ([]*model.Product, error) {
+	// elasticsearch rejects an mget without ids
+	if len(ids) == 0 {
+		return []*model.Product{}, nil
+	}
+
+	var buf bytes.Buffer
+
+	if err := json.NewEncoder(&buf).Encode(Mget{Ids: ids}); err != nil {
+		logrus.Errorf("Failed to encode mget of %d products", len(ids))
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Read)
+	defer cancel()
+
+	res, err := r.client.Mget(&buf, r.client.Mget.WithIndex(index), r.client.Mget.WithContext(ctx))
+	if err != nil {
+		logrus.Errorf("Failed to get %d products", len(ids))
+		return nil, requestError(err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		logrus.Errorf("Error in the mget response. Status code: %d. Response: %s", res.StatusCode, res.String())
+		return nil, responseError(res)
+	}
+
+	var mr MgetResponse
+	if err := json.NewDecoder(res.Body).Decode(&mr); err != nil {
+		logrus.Errorf("Failed to decode mget of %d products", len(ids))
+		return nil, err
+	}
+
+	ps := []*model.Product{}
+	for i := range mr.Docs {
+		if mr.Docs[i].Found {
+			ps = append(ps, mapHitToProduct(&mr.Docs[i].Hit))
+		}
+	}
+
+	return ps, nil
+}
+
+func (r repository) Create(ctx context.Context, p *model.Product) (id string, err error) {
 	d := mapProductToDocument(p)
 
 	var buf bytes.Buffer
@@ -62,24 +123,32 @@ func (r repository) Create(p *model.Product) (id string, err error) {
 		return "", err
 	}
 
-	id = ksuid.New().String()
+	id = p.Id
+	if id == "" {
+		id = ksuid.New().String()
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
 
-	res, err := r.client.Create(index, id, &buf)
+	res, err := r.client.Create(index, id, &buf, r.client.Create.WithContext(ctx))
 	if err != nil {
 		logrus.Errorf("Failed to create product %s", id)
-		return "", err
+		return "", requestError(err)
 	}
+	defer res.Body.Close()
 
 	if res.IsError() {
 		logrus.Errorf("Error in the response for product with id: %s. Status code: %d. Response: %s", id, res.StatusCode, res.String())
-		return "", errors.New("response error")
+		return "", responseError(res)
 	}
 
 	return id, nil
 }
 
-// Update currently updates only name, brand, price, category and image
-func (r repository) Update(p *model.Product) error {
+// Update currently updates only name, brand, price, category and image,
+// the rating is left untouched since it is owned by the reviewing service
+func (r repository) Update(ctx context.Context, p *model.Product) error {
 	d := mapProductToDocument(p)
 	u := Update{Doc: d}
 
@@ -90,21 +159,25 @@ func (r repository) Update(p *model.Product) error {
 		return err
 	}
 
-	res, err := r.client.Update(index, p.Id, &buf)
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	res, err := r.client.Update(index, p.Id, &buf, r.updateOptions(ctx, p.Version)...)
 	if err != nil {
 		logrus.Errorf("Failed to update product %s", p.Id)
-		return err
+		return requestError(err)
 	}
+	defer res.Body.Close()
 
 	if res.IsError() {
 		logrus.Errorf("Error in the response for product with id: %s. Status code: %d. Response: %s", p.Id, res.StatusCode, res.String())
-		return errors.New("response error")
+		return responseError(res)
 	}
 
 	return nil
 }
 
-func (r repository) UpdatePrice(id string, price float32) error {
+func (r repository) UpdatePrice(ctx context.Context, id string, price float32, v *model.Version) error {
 
 	up := map[string]map[string]float32{
 		"doc": {"price": price},
@@ -117,87 +190,215 @@ func (r repository) UpdatePrice(id string, price float32) error {
 		return err
 	}
 
-	res, err := r.client.Update(index, id, &buf)
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	res, err := r.client.Update(index, id, &buf, r.updateOptions(ctx, v)...)
 	if err != nil {
 		logrus.Errorf("Failed to update product %s", id)
-		return err
+		return requestError(err)
 	}
+	defer res.Body.Close()
 
 	if res.IsError() {
 		logrus.Errorf("Error in the response for product with id: %s. Status code: %d. Response: %s", id, res.StatusCode, res.String())
-		return errors.New("response error")
+		return responseError(res)
 	}
 
 	return nil
 }
 
-func (r repository) Delete(id string) error {
-	res, err := r.client.Delete(index, id)
+// updateOptions makes the update conditional on the version, no condition when the version is nil
+func (r repository) updateOptions(ctx context.Context, v *model.Version) []func(*esapi.UpdateRequest) {
+	o := []func(*esapi.UpdateRequest){r.client.Update.WithContext(ctx)}
+	if v == nil {
+		return o
+	}
+	return append(o,
+		r.client.Update.WithIfSeqNo(v.SeqNo),
+		r.client.Update.WithIfPrimaryTerm(v.PrimaryTerm),
+	)
+}
+
+func (r repository) Delete(ctx context.Context, id string) error {
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
+
+	res, err := r.client.Delete(index, id, r.client.Delete.WithContext(ctx))
 	if err != nil {
 		logrus.Errorf("Failed to delete product %s", id)
-		return err
+		return requestError(err)
 	}
+	defer res.Body.Close()
 
 	if res.IsError() {
 		logrus.Errorf("Error in the response for product with id: %s. Status code: %d. Response: %s", id, res.StatusCode, res.String())
-		return errors.New("response error")
+		return responseError(res)
 	}
 
 	return nil
 }
 
-func (r repository) GetByCategory(category string) ([]*model.Product, error) {
+// GetByFilter returns a page of the products matching the filter together with the facets
+func (r repository) GetByFilter(ctx context.Context, f model.Filter, page model.Page) (*model.ProductPage, error) {
+
+	// one extra hit tells whether there is a next page
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"match_all": map[string]interface{}{},
+		},
+		"size": page.Limit + 1,
+		"sort": sortValues,
+	}
+	withFacets(query, f)
+
+	if page.Cursor != "" {
+		after, err := decodeCursor(page.Cursor)
+		if err != nil {
+			logrus.Warnf("Invalid cursor %s", page.Cursor)
+			return nil, err
+		}
+		query["search_after"] = after
+	}
+
+	result, err := r.search(ctx, query)
+	if err != nil {
+		logrus.Errorf("Failed to search products for filter %+v", f)
+		return nil, err
+	}
+
+	hits := result.Hits.Hits
+
+	p := &model.ProductPage{
+		Products: []*model.Product{},
+		Total:    result.Hits.Total.Value,
+		Facets:   mapAggregationsToFacets(result.Aggregations, f),
+	}
+
+	if len(hits) > page.Limit {
+		hits = hits[:page.Limit]
+		if p.NextCursor, err = encodeCursor(hits[len(hits)-1].Sort); err != nil {
+			logrus.Errorf("Failed to encode cursor for filter %+v", f)
+			return nil, err
+		}
+	}
+
+	for _, hit := range hits {
+		p.Products = append(p.Products, mapHitToProduct(&hit))
+	}
+
+	return p, nil
+
+}
+
+// Search runs a fuzzy full-text query over name, brand and category,
+// matches in the name weigh the most followed by the brand
+func (r repository) Search(ctx context.Context, text string, f model.Filter) (*model.SearchResult, error) {
 
-	var buf bytes.Buffer
 	query := map[string]interface{}{
 		"query": map[string]interface{}{
-			"match": map[string]interface{}{
-				"category": category,
+			"multi_match": map[string]interface{}{
+				"query":     text,
+				"fields":    []string{"name^3", "brand^2", "category"},
+				"fuzziness": "AUTO",
+			},
+		},
+		"highlight": map[string]interface{}{
+			"fields": map[string]interface{}{
+				"name":     map[string]interface{}{},
+				"brand":    map[string]interface{}{},
+				"category": map[string]interface{}{},
 			},
 		},
 	}
+	withFacets(query, f)
+
+	result, err := r.search(ctx, query)
+	if err != nil {
+		logrus.Errorf("Failed to search products for %s", text)
+		return nil, err
+	}
+
+	sr := &model.SearchResult{
+		Hits:   []*model.SearchHit{},
+		Total:  result.Hits.Total.Value,
+		Facets: mapAggregationsToFacets(result.Aggregations, f),
+	}
+
+	for _, hit := range result.Hits.Hits {
+		sr.Hits = append(sr.Hits, mapHitToSearchHit(&hit))
+	}
+
+	return sr, nil
+}
+
+func (r repository) search(ctx context.Context, query map[string]interface{}) (*Result, error) {
+
+	var buf bytes.Buffer
 	if err := json.NewEncoder(&buf).Encode(query); err != nil {
-		logrus.Errorf("Failed to encode query for category %s", category)
+		logrus.Errorf("Failed to encode query %v", query)
 		return nil, err
 	}
 
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Search)
+	defer cancel()
+
 	// Perform the search request.
 	res, err := r.client.Search(
-		r.client.Search.WithContext(context.Background()),
+		r.client.Search.WithContext(ctx),
 		r.client.Search.WithIndex(index),
 		r.client.Search.WithBody(&buf),
 		r.client.Search.WithTrackTotalHits(true),
 		r.client.Search.WithPretty(),
 	)
 	if err != nil {
-		logrus.Errorf("Failed to get response for category %s", category)
-		return nil, err
+		logrus.Errorf("Failed to get search response")
+		return nil, requestError(err)
 	}
 	defer res.Body.Close()
 
 	if res.IsError() {
-		logrus.Errorf("Error in the response for category %s. Status code: %d. Response: %s", category, res.StatusCode, res.String())
-		return nil, errors.New("response error")
+		logrus.Errorf("Error in the search response. Status code: %d. Response: %s", res.StatusCode, res.String())
+		return nil, responseError(res)
 	}
 
 	var result *Result
 
 	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-		logrus.Errorf("Failed to decode result for category %s", category)
+		logrus.Errorf("Failed to decode search result")
 		return nil, err
 	}
 
-	products := []*model.Product{}
+	return result, nil
+}
 
-	for _, hit := range result.Hits.Hits {
-		products = append(products, mapHitToProduct(&hit))
+func (r repository) UpdateRating(ctx context.Context, id string, rating *model.Rating) error {
+
+	up := map[string]map[string]*Rating{
+		"doc": {"rating": mapRatingToDocumentRating(rating)},
 	}
 
-	return products, nil
+	var buf bytes.Buffer
 
-}
+	if err := json.NewEncoder(&buf).Encode(up); err != nil {
+		logrus.Errorf("Failed to encode rating update for product %s", id)
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.timeouts.Write)
+	defer cancel()
 
-func (r repository) UpdateRating(id string, rating *model.Rating) error {
-	// ToDo
-	return errors.New("not implemented yet")
+	res, err := r.client.Update(index, id, &buf, r.updateOptions(ctx, nil)...)
+	if err != nil {
+		logrus.Errorf("Failed to update rating of product %s", id)
+		return requestError(err)
+	}
+	defer res.Body.Close()
+
+	if res.IsError() {
+		logrus.Errorf("Error in the response for product with id: %s. Status code: %d. Response: %s", id, res.StatusCode, res.String())
+		return responseError(res)
+	}
+
+	return nil
 }
@@ -0,0 +1,202 @@
+package es
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+
+	"github.com/pejovski/catalog/model"
+	repo "github.com/pejovski/catalog/repository"
+	"github.com/pejovski/catalog/repository/repositorytest"
+)
+
+// TestRepository runs the conformance suite against the cluster in ES_TEST_URL (e.g. http://127.0.0.1:9200).
+// The products of that cluster are deleted, never point it to a cluster with data you need.
+func TestRepository(t *testing.T) {
+	url := os.Getenv("ES_TEST_URL")
+	if url == "" {
+		t.Skip("ES_TEST_URL is not set")
+	}
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{url}})
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+
+	if err := SetupIndex(client); err != nil {
+		t.Fatalf("Failed to set up index: %s", err)
+	}
+
+	repositorytest.Run(t, func(t *testing.T) repo.Repository {
+		res, err := client.DeleteByQuery(
+			[]string{index},
+			strings.NewReader(`{"query": {"match_all": {}}}`),
+			client.DeleteByQuery.WithRefresh(true),
+		)
+		if err != nil || res.IsError() {
+			t.Fatalf("Failed to delete products: %v %v", err, res)
+		}
+		res.Body.Close()
+
+		return refreshing{Repository: NewRepository(client, DefaultTimeouts), client: client}
+	})
+}
+
+// TestOutbox runs the outbox suite against the cluster in ES_TEST_URL, the events of that cluster are deleted
+func TestOutbox(t *testing.T) {
+	url := os.Getenv("ES_TEST_URL")
+	if url == "" {
+		t.Skip("ES_TEST_URL is not set")
+	}
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{url}})
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+
+	if err := SetupOutbox(client); err != nil {
+		t.Fatalf("Failed to set up outbox: %s", err)
+	}
+
+	repositorytest.RunOutbox(t, func(t *testing.T) repo.Outbox {
+		res, err := client.DeleteByQuery(
+			[]string{outboxIndex},
+			strings.NewReader(`{"query": {"match_all": {}}}`),
+			client.DeleteByQuery.WithRefresh(true),
+		)
+		if err != nil || res.IsError() {
+			t.Fatalf("Failed to delete events: %v %v", err, res)
+		}
+		res.Body.Close()
+
+		return refreshingOutbox{Outbox: NewOutbox(client, DefaultTimeouts), client: client}
+	})
+}
+
+// TestDedup runs the dedup suite against the cluster in ES_TEST_URL, the messages of that cluster are deleted
+func TestDedup(t *testing.T) {
+	url := os.Getenv("ES_TEST_URL")
+	if url == "" {
+		t.Skip("ES_TEST_URL is not set")
+	}
+
+	client, err := elasticsearch.NewClient(elasticsearch.Config{Addresses: []string{url}})
+	if err != nil {
+		t.Fatalf("Failed to create client: %s", err)
+	}
+
+	if err := SetupDedup(client); err != nil {
+		t.Fatalf("Failed to set up dedup: %s", err)
+	}
+
+	repositorytest.RunDedup(t, func(t *testing.T, ttl time.Duration) repo.Dedup {
+		res, err := client.DeleteByQuery(
+			[]string{dedupIndex},
+			strings.NewReader(`{"query": {"match_all": {}}}`),
+			client.DeleteByQuery.WithRefresh(true),
+		)
+		if err != nil || res.IsError() {
+			t.Fatalf("Failed to delete messages: %v %v", err, res)
+		}
+		res.Body.Close()
+
+		return refreshingDedup{Dedup: NewDedup(client, DefaultTimeouts, ttl), client: client}
+	})
+}
+
+// refreshing makes writes visible to search right away, elasticsearch is near real-time
+type refreshing struct {
+	repo.Repository
+	client *elasticsearch.Client
+}
+
+func (r refreshing) refresh() {
+	if res, err := r.client.Indices.Refresh(r.client.Indices.Refresh.WithIndex(index)); err == nil {
+		res.Body.Close()
+	}
+}
+
+func (r refreshing) Create(ctx context.Context, p *model.Product) (string, error) {
+	defer r.refresh()
+	return r.Repository.Create(ctx, p)
+}
+
+func (r refreshing) Update(ctx context.Context, p *model.Product) error {
+	defer r.refresh()
+	return r.Repository.Update(ctx, p)
+}
+
+func (r refreshing) UpdatePrice(ctx context.Context, id string, price float32, v *model.Version) error {
+	defer r.refresh()
+	return r.Repository.UpdatePrice(ctx, id, price, v)
+}
+
+func (r refreshing) UpdateRating(ctx context.Context, id string, rating *model.Rating) error {
+	defer r.refresh()
+	return r.Repository.UpdateRating(ctx, id, rating)
+}
+
+func (r refreshing) Delete(ctx context.Context, id string) error {
+	defer r.refresh()
+	return r.Repository.Delete(ctx, id)
+}
+
+func (r refreshing) Bulk(ctx context.Context, ops []*model.BulkOperation) ([]*model.BulkResult, error) {
+	defer r.refresh()
+	return r.Repository.Bulk(ctx, ops)
+}
+
+type refreshingOutbox struct {
+	repo.Outbox
+	client *elasticsearch.Client
+}
+
+func (o refreshingOutbox) refresh() {
+	if res, err := o.client.Indices.Refresh(o.client.Indices.Refresh.WithIndex(outboxIndex)); err == nil {
+		res.Body.Close()
+	}
+}
+
+func (o refreshingOutbox) Add(ctx context.Context, events ...*model.Event) error {
+	defer o.refresh()
+	return o.Outbox.Add(ctx, events...)
+}
+
+func (o refreshingOutbox) Ready(ctx context.Context, ids ...string) error {
+	defer o.refresh()
+	return o.Outbox.Ready(ctx, ids...)
+}
+
+func (o refreshingOutbox) Remove(ctx context.Context, ids ...string) error {
+	defer o.refresh()
+	return o.Outbox.Remove(ctx, ids...)
+}
+
+func (o refreshingOutbox) Failed(ctx context.Context, id string, reason error) error {
+	defer o.refresh()
+	return o.Outbox.Failed(ctx, id, reason)
+}
+
+func (o refreshingOutbox) Purge(ctx context.Context, sentBefore time.Time) error {
+	defer o.refresh()
+	return o.Outbox.Purge(ctx, sentBefore)
+}
+
+// refreshingDedup makes the marks visible to the purge query right away
+type refreshingDedup struct {
+	repo.Dedup
+	client *elasticsearch.Client
+}
+
+func (d refreshingDedup) Mark(ctx context.Context, id string) error {
+	defer func() {
+		if res, err := d.client.Indices.Refresh(d.client.Indices.Refresh.WithIndex(dedupIndex)); err == nil {
+			res.Body.Close()
+		}
+	}()
+	return d.Dedup.Mark(ctx, id)
+}
@@ -0,0 +1,76 @@
+package memory
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+
+	repo "github.com/pejovski/catalog/repository"
+)
+
+type mark struct {
+	id       string
+	markedAt time.Time
+}
+
+// dedup keeps up to max messages in memory, the oldest are forgotten first
+type dedup struct {
+	mu  sync.Mutex
+	ttl time.Duration
+	max int
+	// marks from the oldest to the newest
+	marks    *list.List
+	elements map[string]*list.Element
+}
+
+func NewDedup(ttl time.Duration, max int) repo.Dedup {
+	return &dedup{ttl: ttl, max: max, marks: list.New(), elements: map[string]*list.Element{}}
+}
+
+func (d *dedup) Seen(ctx context.Context, id string) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	e, ok := d.elements[id]
+	if !ok {
+		return false, nil
+	}
+
+	return time.Since(e.Value.(*mark).markedAt) < d.ttl, nil
+}
+
+func (d *dedup) Mark(ctx context.Context, id string) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if e, ok := d.elements[id]; ok {
+		e.Value.(*mark).markedAt = time.Now()
+		d.marks.MoveToBack(e)
+		return nil
+	}
+
+	d.elements[id] = d.marks.PushBack(&mark{id: id, markedAt: time.Now()})
+
+	for d.marks.Len() > d.max {
+		d.remove(d.marks.Front())
+	}
+
+	return nil
+}
+
+func (d *dedup) Purge(ctx context.Context) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for e := d.marks.Front(); e != nil && time.Since(e.Value.(*mark).markedAt) >= d.ttl; e = d.marks.Front() {
+		d.remove(e)
+	}
+
+	return nil
+}
+
+func (d *dedup) remove(e *list.Element) {
+	d.marks.Remove(e)
+	delete(d.elements, e.Value.(*mark).id)
+}
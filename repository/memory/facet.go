@@ -0,0 +1,122 @@
+package memory
+
+import (
+	"sort"
+
+	"github.com/pejovski/catalog/model"
+)
+
+const (
+	facetCategories = "categories"
+	facetBrands     = "brands"
+	facetPrices     = "prices"
+	facetRatings    = "ratings"
+
+	// number of brand and category buckets returned
+	facetSize = 20
+)
+
+// filter returns the products matching the selections of all facets except the excluded one
+func filter(ps []*model.Product, f model.Filter, exclude string) []*model.Product {
+	matched := []*model.Product{}
+
+	for _, p := range ps {
+		if exclude != facetCategories && !containsValue(f.Categories, p.Category) {
+			continue
+		}
+		if exclude != facetBrands && !containsValue(f.Brands, p.Brand) {
+			continue
+		}
+		if exclude != facetPrices && !inRanges(model.PriceRanges, f.Prices, p.Price) {
+			continue
+		}
+		if exclude != facetRatings && !inRanges(model.RatingRanges, f.Ratings, p.Stars) {
+			continue
+		}
+		matched = append(matched, p)
+	}
+
+	return matched
+}
+
+// containsValue is true when nothing is selected or the value is selected
+func containsValue(selected []string, v string) bool {
+	if len(selected) == 0 {
+		return true
+	}
+	for _, s := range selected {
+		if s == v {
+			return true
+		}
+	}
+	return false
+}
+
+func inRanges(ranges []model.Range, selected []string, v float32) bool {
+	if len(selected) == 0 {
+		return true
+	}
+	for _, k := range selected {
+		if r, ok := model.FindRange(ranges, k); ok && r.Contains(v) {
+			return true
+		}
+	}
+	return false
+}
+
+// facets counts every facet with the other facets' selections applied, like the elasticsearch aggregations
+func facets(ps []*model.Product, f model.Filter) *model.Facets {
+	return &model.Facets{
+		Categories: terms(filter(ps, f, facetCategories), f.Categories, func(p *model.Product) string { return p.Category }),
+		Brands:     terms(filter(ps, f, facetBrands), f.Brands, func(p *model.Product) string { return p.Brand }),
+		Prices:     ranges(filter(ps, f, facetPrices), model.PriceRanges, f.Prices, func(p *model.Product) float32 { return p.Price }),
+		Ratings:    ranges(filter(ps, f, facetRatings), model.RatingRanges, f.Ratings, func(p *model.Product) float32 { return p.Stars }),
+	}
+}
+
+// terms orders the buckets by count and then by key like a terms aggregation
+func terms(ps []*model.Product, selected []string, value func(p *model.Product) string) []*model.Bucket {
+	counts := map[string]int{}
+	for _, p := range ps {
+		counts[value(p)]++
+	}
+
+	bs := []*model.Bucket{}
+	for k, c := range counts {
+		bs = append(bs, &model.Bucket{Key: k, Count: c, Selected: containsSelected(selected, k)})
+	}
+
+	sort.Slice(bs, func(i, j int) bool {
+		if bs[i].Count != bs[j].Count {
+			return bs[i].Count > bs[j].Count
+		}
+		return bs[i].Key < bs[j].Key
+	})
+
+	if len(bs) > facetSize {
+		bs = bs[:facetSize]
+	}
+
+	return bs
+}
+
+// ranges returns a bucket for every range, empty ones included, like a range aggregation
+func ranges(ps []*model.Product, rs []model.Range, selected []string, value func(p *model.Product) float32) []*model.Bucket {
+	bs := []*model.Bucket{}
+
+	for _, r := range rs {
+		b := &model.Bucket{Key: r.Key, Selected: containsSelected(selected, r.Key)}
+		for _, p := range ps {
+			if r.Contains(value(p)) {
+				b.Count++
+			}
+		}
+		bs = append(bs, b)
+	}
+
+	return bs
+}
+
+func containsSelected(selected []string, v string) bool {
+	return len(selected) > 0 && containsValue(selected, v)
+}
@@ -0,0 +1,146 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/pejovski/catalog/model"
+	repo "github.com/pejovski/catalog/repository"
+)
+
+const (
+	statusPending = "pending"
+	statusReady   = "ready"
+	statusSent    = "sent"
+	statusParked  = "parked"
+)
+
+type entry struct {
+	event  model.Event
+	status string
+	// when the event was sent or parked
+	sentAt time.Time
+}
+
+// outbox keeps the events in memory, they are lost on shutdown
+type outbox struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+}
+
+func NewOutbox() repo.Outbox {
+	return &outbox{entries: map[string]*entry{}}
+}
+
+func (o *outbox) Add(ctx context.Context, events ...*model.Event) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, e := range events {
+		o.entries[e.Id] = &entry{event: *e, status: statusPending}
+	}
+
+	return nil
+}
+
+func (o *outbox) Ready(ctx context.Context, ids ...string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, id := range ids {
+		if e, ok := o.entries[id]; ok && e.status == statusPending {
+			e.status = statusReady
+		}
+	}
+
+	return nil
+}
+
+func (o *outbox) Remove(ctx context.Context, ids ...string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for _, id := range ids {
+		delete(o.entries, id)
+	}
+
+	return nil
+}
+
+func (o *outbox) Unsent(ctx context.Context, pendingBefore time.Time, limit int) ([]*model.Event, error) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	events := []*model.Event{}
+	for _, e := range o.entries {
+		if e.status == statusReady || (e.status == statusPending && e.event.CreatedAt.Before(pendingBefore)) {
+			ev := e.event
+			events = append(events, &ev)
+		}
+	}
+
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].CreatedAt.Equal(events[j].CreatedAt) {
+			return events[i].Id < events[j].Id
+		}
+		return events[i].CreatedAt.Before(events[j].CreatedAt)
+	})
+
+	if len(events) > limit {
+		events = events[:limit]
+	}
+
+	return events, nil
+}
+
+func (o *outbox) Sent(ctx context.Context, ids ...string) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	now := time.Now()
+	for _, id := range ids {
+		if e, ok := o.entries[id]; ok {
+			e.status, e.sentAt = statusSent, now
+		}
+	}
+
+	return nil
+}
+
+func (o *outbox) Failed(ctx context.Context, id string, reason error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if e, ok := o.entries[id]; ok {
+		e.event.Attempts++
+	}
+
+	return nil
+}
+
+func (o *outbox) Park(ctx context.Context, id string, reason error) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	if e, ok := o.entries[id]; ok {
+		e.status, e.sentAt = statusParked, time.Now()
+		e.event.Attempts++
+	}
+
+	return nil
+}
+
+func (o *outbox) Purge(ctx context.Context, sentBefore time.Time) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	for id, e := range o.entries {
+		if (e.status == statusSent || e.status == statusParked) && e.sentAt.Before(sentBefore) {
+			delete(o.entries, id)
+		}
+	}
+
+	return nil
+}
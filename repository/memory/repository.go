@@ -0,0 +1,255 @@
+package memory
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"sort"
+	"sync"
+
+	"github.com/segmentio/ksuid"
+
+	myerr "github.com/pejovski/catalog/error"
+	"github.com/pejovski/catalog/model"
+	repo "github.com/pejovski/catalog/repository"
+)
+
+// primaryTerm never changes since there is no failover in memory
+const primaryTerm = 1
+
+// repository keeps the products in memory with the semantics of the elasticsearch repository,
+// it is meant for local development and tests
+type repository struct {
+	mu       sync.RWMutex
+	products map[string]*model.Product
+	// incremented on every write like the elasticsearch sequence number
+	seqNo int
+}
+
+func NewRepository() repo.Repository {
+	return &repository{products: map[string]*model.Product{}}
+}
+
+func (r *repository) Get(ctx context.Context, id string) (*model.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	p, ok := r.products[id]
+	if !ok {
+		return nil, myerr.New(myerr.ErrNotFound, "product not found")
+	}
+
+	return clone(p), nil
+}
+
+func (r *repository) GetByIds(ctx context.Context, ids []string) ([]*model.Product, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ps := []*model.Product{}
+	for _, id := range ids {
+		if p, ok := r.products[id]; ok {
+			ps = append(ps, clone(p))
+		}
+	}
+
+	return ps, nil
+}
+
+func (r *repository) Create(ctx context.Context, p *model.Product) (id string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.create(p)
+}
+
+// Update updates only name, brand, price, category and image like the elasticsearch repository
+func (r *repository) Update(ctx context.Context, p *model.Product) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.update(p.Id, p.Version, func(s *model.Product) {
+		s.Name, s.Brand, s.Price, s.Category, s.Image = p.Name, p.Brand, p.Price, p.Category, p.Image
+	})
+}
+
+func (r *repository) UpdatePrice(ctx context.Context, id string, price float32, v *model.Version) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.update(id, v, func(s *model.Product) {
+		s.Price = price
+	})
+}
+
+func (r *repository) UpdateRating(ctx context.Context, id string, rating *model.Rating) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.update(id, nil, func(s *model.Product) {
+		s.Rating = *rating
+	})
+}
+
+func (r *repository) Delete(ctx context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.delete(id, nil)
+}
+
+func (r *repository) GetByFilter(ctx context.Context, f model.Filter, page model.Page) (*model.ProductPage, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	after := ""
+	if page.Cursor != "" {
+		b, err := base64.RawURLEncoding.DecodeString(page.Cursor)
+		if err != nil || len(b) == 0 {
+			return nil, myerr.ErrInvalidCursor
+		}
+		after = string(b)
+	}
+
+	all := r.sorted()
+	ps := filter(all, f, "")
+
+	p := &model.ProductPage{Products: []*model.Product{}, Total: len(ps), Facets: facets(all, f)}
+
+	for _, sp := range ps {
+		if sp.Id <= after {
+			continue
+		}
+		if len(p.Products) == page.Limit {
+			p.NextCursor = base64.RawURLEncoding.EncodeToString([]byte(p.Products[len(p.Products)-1].Id))
+			break
+		}
+		p.Products = append(p.Products, clone(sp))
+	}
+
+	return p, nil
+}
+
+func (r *repository) Bulk(ctx context.Context, ops []*model.BulkOperation) ([]*model.BulkResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	results := []*model.BulkResult{}
+
+	for _, op := range ops {
+		res := &model.BulkResult{Type: op.Type, Id: op.Product.Id, Status: http.StatusOK}
+
+		var err error
+		switch op.Type {
+		case model.OpCreate:
+			var id string
+			if id, err = r.create(op.Product); err == nil {
+				res.Id, res.Status = id, http.StatusCreated
+			}
+		case model.OpUpdate:
+			p := op.Product
+			err = r.update(p.Id, p.Version, func(s *model.Product) {
+				s.Name, s.Brand, s.Price, s.Category, s.Image = p.Name, p.Brand, p.Price, p.Category, p.Image
+			})
+		case model.OpDelete:
+			err = r.delete(op.Product.Id, op.Product.Version)
+		}
+
+		if err != nil {
+			res.Status = http.StatusNotFound
+			if errors.Is(err, myerr.ErrConflict) {
+				res.Status = http.StatusConflict
+			}
+			res.Error = err.Error()
+		}
+
+		results = append(results, res)
+	}
+
+	return results, nil
+}
+
+// create must be called with the lock held
+func (r *repository) create(p *model.Product) (string, error) {
+	id := p.Id
+	if id == "" {
+		id = ksuid.New().String()
+	}
+	if _, ok := r.products[id]; ok {
+		return "", myerr.New(myerr.ErrConflict, "product already exists")
+	}
+
+	r.seqNo++
+
+	// the rating is maintained only by UpdateRating
+	s := &model.Product{
+		Id:       id,
+		Name:     p.Name,
+		Brand:    p.Brand,
+		Price:    p.Price,
+		Category: p.Category,
+		Image:    p.Image,
+		Version:  &model.Version{SeqNo: r.seqNo, PrimaryTerm: primaryTerm},
+	}
+	r.products[s.Id] = s
+
+	return s.Id, nil
+}
+
+// update must be called with the lock held, a nil version updates unconditionally
+func (r *repository) update(id string, v *model.Version, apply func(s *model.Product)) error {
+	s, ok := r.products[id]
+	if !ok {
+		return myerr.New(myerr.ErrNotFound, "product not found")
+	}
+
+	if v != nil && *v != *s.Version {
+		return myerr.New(myerr.ErrConflict, "product changed")
+	}
+
+	r.seqNo++
+
+	apply(s)
+	s.Version = &model.Version{SeqNo: r.seqNo, PrimaryTerm: primaryTerm}
+
+	return nil
+}
+
+// delete must be called with the lock held, a nil version deletes unconditionally
+func (r *repository) delete(id string, v *model.Version) error {
+	s, ok := r.products[id]
+	if !ok {
+		return myerr.New(myerr.ErrNotFound, "product not found")
+	}
+
+	if v != nil && *v != *s.Version {
+		return myerr.New(myerr.ErrConflict, "product changed")
+	}
+
+	r.seqNo++
+	delete(r.products, id)
+
+	return nil
+}
+
+// sorted returns the products ordered by id, the tie-breaker of the elasticsearch listing
+func (r *repository) sorted() []*model.Product {
+	ps := []*model.Product{}
+	for _, p := range r.products {
+		ps = append(ps, p)
+	}
+
+	sort.Slice(ps, func(i, j int) bool { return ps[i].Id < ps[j].Id })
+
+	return ps
+}
+
+func clone(p *model.Product) *model.Product {
+	c := *p
+	if p.Version != nil {
+		v := *p.Version
+		c.Version = &v
+	}
+	return &c
+}
@@ -0,0 +1,45 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	repo "github.com/pejovski/catalog/repository"
+	"github.com/pejovski/catalog/repository/repositorytest"
+)
+
+func TestRepository(t *testing.T) {
+	repositorytest.Run(t, func(t *testing.T) repo.Repository {
+		return NewRepository()
+	})
+}
+
+func TestOutbox(t *testing.T) {
+	repositorytest.RunOutbox(t, func(t *testing.T) repo.Outbox {
+		return NewOutbox()
+	})
+}
+
+func TestDedup(t *testing.T) {
+	repositorytest.RunDedup(t, func(t *testing.T, ttl time.Duration) repo.Dedup {
+		return NewDedup(ttl, 100)
+	})
+}
+
+func TestDedupBounded(t *testing.T) {
+	d := NewDedup(time.Hour, 2)
+	ctx := context.Background()
+
+	for _, id := range []string{"1", "2", "3"} {
+		if err := d.Mark(ctx, id); err != nil {
+			t.Fatalf("Failed to mark %s: %s", id, err)
+		}
+	}
+
+	for id, expected := range map[string]bool{"1": false, "2": true, "3": true} {
+		if seen, _ := d.Seen(ctx, id); seen != expected {
+			t.Errorf("Expected seen %t for %s, got %t", expected, id, seen)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"unicode"
+
+	"github.com/pejovski/catalog/model"
+)
+
+// searchSize is the number of hits returned, the elasticsearch default
+const searchSize = 10
+
+// field of a product matched by the full-text search, with its boost
+type field struct {
+	name  string
+	boost int
+	value func(p *model.Product) string
+	// keyword fields are matched as a whole, text fields word by word
+	keyword bool
+}
+
+var searchFields = []field{
+	{name: "name", boost: 3, value: func(p *model.Product) string { return p.Name }},
+	{name: "brand", boost: 2, value: func(p *model.Product) string { return p.Brand }},
+	{name: "category", boost: 1, value: func(p *model.Product) string { return p.Category }, keyword: true},
+}
+
+// Search approximates the elasticsearch multi_match query: words match with AUTO fuzziness,
+// a match in the name weighs 3, in the brand 2 and in the category 1
+func (r *repository) Search(ctx context.Context, text string, f model.Filter) (*model.SearchResult, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	terms := tokenize(text)
+
+	type scored struct {
+		hit   *model.SearchHit
+		score int
+	}
+
+	matched := []*model.Product{}
+	hits := map[string]*scored{}
+
+	for _, p := range r.sorted() {
+		s := &scored{hit: &model.SearchHit{Product: clone(p), Highlights: map[string][]string{}}}
+
+		for _, fd := range searchFields {
+			v := fd.value(p)
+			if fd.keyword {
+				if fuzzyMatch(text, v) {
+					s.score += fd.boost
+					s.hit.Highlights[fd.name] = []string{"<em>" + v + "</em>"}
+				}
+				continue
+			}
+			if fragment, n := highlight(v, terms); n > 0 {
+				s.score += fd.boost * n
+				s.hit.Highlights[fd.name] = []string{fragment}
+			}
+		}
+
+		if s.score > 0 {
+			matched = append(matched, p)
+			hits[p.Id] = s
+		}
+	}
+
+	ps := filter(matched, f, "")
+
+	sr := &model.SearchResult{Hits: []*model.SearchHit{}, Total: len(ps), Facets: facets(matched, f)}
+
+	ss := []*scored{}
+	for _, p := range ps {
+		ss = append(ss, hits[p.Id])
+	}
+
+	// ps is ordered by id, a stable sort keeps it as the tie-breaker
+	sort.SliceStable(ss, func(i, j int) bool { return ss[i].score > ss[j].score })
+
+	for i, s := range ss {
+		if i == searchSize {
+			break
+		}
+		sr.Hits = append(sr.Hits, s.hit)
+	}
+
+	return sr, nil
+}
+
+func tokenize(s string) []string {
+	return strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+}
+
+// highlight wraps the words matching any of the terms in <em> and returns how many matched
+func highlight(v string, terms []string) (string, int) {
+	words := strings.Fields(v)
+	n := 0
+
+	for i, w := range words {
+		for _, t := range tokenize(w) {
+			if matchesAny(t, terms) {
+				words[i] = "<em>" + w + "</em>"
+				n++
+				break
+			}
+		}
+	}
+
+	return strings.Join(words, " "), n
+}
+
+func matchesAny(word string, terms []string) bool {
+	for _, t := range terms {
+		if fuzzyMatch(t, word) {
+			return true
+		}
+	}
+	return false
+}
+
+// fuzzyMatch allows the edits of the elasticsearch AUTO fuzziness: none up to 2 characters,
+// one up to 5 and two for longer terms
+func fuzzyMatch(term, v string) bool {
+	edits := 2
+	switch n := len([]rune(term)); {
+	case n <= 2:
+		edits = 0
+	case n <= 5:
+		edits = 1
+	}
+	return distance(term, v) <= edits
+}
+
+// distance is the Levenshtein distance of a and b
+func distance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+
+	prev := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur := make([]int, len(rb)+1)
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = minimum(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev = cur
+	}
+
+	return prev[len(rb)]
+}
+
+func minimum(vs ...int) int {
+	m := vs[0]
+	for _, v := range vs[1:] {
+		if v < m {
+			m = v
+		}
+	}
+	return m
+}
@@ -1,13 +1,56 @@
 package repository
 
-import "github.com/pejovski/catalog/model"
+import (
+	"context"
+	"time"
+
+	"github.com/pejovski/catalog/model"
+)
 
 type Repository interface {
-	Get(id string) (*model.Product, error)
-	Create(p *model.Product) (id string, err error)
-	Update(p *model.Product) error
-	Delete(id string) error
-	GetByCategory(category string) ([]*model.Product, error)
-	UpdatePrice(id string, price float32) error
-	UpdateRating(id string, r *model.Rating) error
+	Get(ctx context.Context, id string) (*model.Product, error)
+	// GetByIds returns the products with the ids in their order, missing products are left out
+	GetByIds(ctx context.Context, ids []string) ([]*model.Product, error)
+	// Create stores the product under p.Id, or a generated id when it is empty, an existing id is a conflict
+	Create(ctx context.Context, p *model.Product) (id string, err error)
+	// the update is conditional on p.Version when set
+	Update(ctx context.Context, p *model.Product) error
+	Delete(ctx context.Context, id string) error
+	GetByFilter(ctx context.Context, f model.Filter, page model.Page) (*model.ProductPage, error)
+	Search(ctx context.Context, text string, f model.Filter) (*model.SearchResult, error)
+	// a nil version updates the price unconditionally
+	UpdatePrice(ctx context.Context, id string, price float32, v *model.Version) error
+	UpdateRating(ctx context.Context, id string, r *model.Rating) error
+	// Bulk executes the operations and returns a result for each, in the same order,
+	// products are created like by Create and updates and deletes are conditional on Product.Version when set
+	Bulk(ctx context.Context, ops []*model.BulkOperation) ([]*model.BulkResult, error)
+}
+
+// Outbox stores the events of product writes until they are published.
+// An event is added as pending before the write, made ready once the write succeeds
+// and removed when it is rejected, pending events older than a grace period are published
+// anyway since the outcome of the write may be unknown, e.g. after a timeout or a crash.
+type Outbox interface {
+	Add(ctx context.Context, events ...*model.Event) error
+	Ready(ctx context.Context, ids ...string) error
+	Remove(ctx context.Context, ids ...string) error
+	// Unsent returns the ready events and the pending ones created before pendingBefore, oldest first
+	Unsent(ctx context.Context, pendingBefore time.Time, limit int) ([]*model.Event, error)
+	Sent(ctx context.Context, ids ...string) error
+	// Failed counts a failed publishing attempt
+	Failed(ctx context.Context, id string, reason error) error
+	// Park sets aside an event which cannot be published for now, it is no longer unsent and is kept like a sent one
+	Park(ctx context.Context, id string, reason error) error
+	// Purge deletes the events sent or parked before the given time
+	Purge(ctx context.Context, sentBefore time.Time) error
+}
+
+// Dedup remembers the consumed messages which were handled, so their redeliveries are skipped.
+// A message is forgotten after the ttl of the store, which must be longer than the redeliveries take.
+type Dedup interface {
+	// Seen reports whether the message was handled within the ttl
+	Seen(ctx context.Context, id string) (bool, error)
+	Mark(ctx context.Context, id string) error
+	// Purge deletes the messages older than the ttl
+	Purge(ctx context.Context) error
 }
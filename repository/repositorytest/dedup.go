@@ -0,0 +1,83 @@
+package repositorytest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ksuid"
+
+	"github.com/pejovski/catalog/repository"
+)
+
+// RunDedup runs the suite of repository.Dedup, newDedup must return an empty store with the ttl on every call
+func RunDedup(t *testing.T, newDedup func(t *testing.T, ttl time.Duration) repository.Dedup) {
+	tests := map[string]func(t *testing.T, newDedup func(t *testing.T, ttl time.Duration) repository.Dedup){
+		"Mark":    testDedupMark,
+		"Expired": testDedupExpired,
+		"Purge":   testDedupPurge,
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			test(t, newDedup)
+		})
+	}
+}
+
+func testDedupMark(t *testing.T, newDedup func(t *testing.T, ttl time.Duration) repository.Dedup) {
+	d := newDedup(t, time.Hour)
+	id := ksuid.New().String()
+
+	seen(t, d, id, false)
+	if err := d.Mark(context.Background(), id); err != nil {
+		t.Fatalf("Failed to mark: %s", err)
+	}
+	seen(t, d, id, true)
+	seen(t, d, ksuid.New().String(), false)
+}
+
+func testDedupExpired(t *testing.T, newDedup func(t *testing.T, ttl time.Duration) repository.Dedup) {
+	d := newDedup(t, 100*time.Millisecond)
+	id := ksuid.New().String()
+
+	if err := d.Mark(context.Background(), id); err != nil {
+		t.Fatalf("Failed to mark: %s", err)
+	}
+	time.Sleep(150 * time.Millisecond)
+
+	seen(t, d, id, false)
+}
+
+func testDedupPurge(t *testing.T, newDedup func(t *testing.T, ttl time.Duration) repository.Dedup) {
+	d := newDedup(t, 200*time.Millisecond)
+	expired, live := ksuid.New().String(), ksuid.New().String()
+
+	if err := d.Mark(context.Background(), expired); err != nil {
+		t.Fatalf("Failed to mark: %s", err)
+	}
+	time.Sleep(250 * time.Millisecond)
+	if err := d.Mark(context.Background(), live); err != nil {
+		t.Fatalf("Failed to mark: %s", err)
+	}
+
+	if err := d.Purge(context.Background()); err != nil {
+		t.Fatalf("Failed to purge: %s", err)
+	}
+
+	seen(t, d, expired, false)
+	seen(t, d, live, true)
+}
+
+func seen(t *testing.T, d repository.Dedup, id string, expected bool) {
+	t.Helper()
+
+	ok, err := d.Seen(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Failed to check %s: %s", id, err)
+	}
+	if ok != expected {
+		t.Errorf("Expected seen %t for %s, got %t", expected, id, ok)
+	}
+}
@@ -0,0 +1,204 @@
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/segmentio/ksuid"
+
+	"github.com/pejovski/catalog/model"
+	"github.com/pejovski/catalog/repository"
+)
+
+// RunOutbox runs the suite of repository.Outbox, newOutbox must return an empty outbox on every call
+func RunOutbox(t *testing.T, newOutbox func(t *testing.T) repository.Outbox) {
+	tests := map[string]func(t *testing.T, o repository.Outbox){
+		"ReadyInOrder": testOutboxReadyInOrder,
+		"Snapshot":     testOutboxSnapshot,
+		"NoSnapshot":   testOutboxNoSnapshot,
+		"PendingGrace": testOutboxPendingGrace,
+		"Remove":       testOutboxRemove,
+		"Sent":         testOutboxSent,
+		"Failed":       testOutboxFailed,
+		"Park":         testOutboxPark,
+		"Purge":        testOutboxPurge,
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			test(t, newOutbox(t))
+		})
+	}
+}
+
+func event(typ string, productId string, createdAt time.Time) *model.Event {
+	return &model.Event{Id: ksuid.New().String(), Type: typ, ProductId: productId, CreatedAt: createdAt}
+}
+
+func add(t *testing.T, o repository.Outbox, events ...*model.Event) {
+	if err := o.Add(context.Background(), events...); err != nil {
+		t.Fatalf("Expected events to be added, got %s", err)
+	}
+}
+
+func unsent(t *testing.T, o repository.Outbox, pendingBefore time.Time) []*model.Event {
+	events, err := o.Unsent(context.Background(), pendingBefore, 10)
+	if err != nil {
+		t.Fatalf("Expected unsent events, got %s", err)
+	}
+	return events
+}
+
+// long ago makes the pending events wait for Ready
+var longAgo = time.Unix(0, 0)
+
+func testOutboxReadyInOrder(t *testing.T, o repository.Outbox) {
+	now := time.Now()
+	second := event(model.EventProductPriceUpdated, "1", now)
+	first := event(model.EventProductUpdated, "1", now.Add(-time.Millisecond))
+	add(t, o, second, first)
+
+	if events := unsent(t, o, longAgo); len(events) != 0 {
+		t.Fatalf("Expected pending events not to be unsent, got %d", len(events))
+	}
+
+	if err := o.Ready(context.Background(), first.Id, second.Id); err != nil {
+		t.Fatalf("Expected events to be ready, got %s", err)
+	}
+
+	events := unsent(t, o, longAgo)
+	if len(events) != 2 || events[0].Id != first.Id || events[1].Id != second.Id {
+		t.Fatalf("Expected both events oldest first, got %+v", events)
+	}
+
+	e := events[1]
+	if e.Type != second.Type || e.ProductId != second.ProductId || !e.CreatedAt.Equal(second.CreatedAt) {
+		t.Errorf("Expected the added event, got %+v", e)
+	}
+}
+
+func testOutboxSnapshot(t *testing.T, o repository.Outbox) {
+	e := event(model.EventProductPriceUpdated, "1", time.Now())
+	e.Before = &model.Product{Id: "1", Name: "Galaxy S10", Brand: "Samsung", Price: 800, Category: "phones", Image: "galaxy.jpg"}
+	e.Before.Rating = model.Rating{Stars: 4.5, Customers: 10}
+	after := *e.Before
+	after.Price = 750
+	e.After = &after
+	add(t, o, e)
+	_ = o.Ready(context.Background(), e.Id)
+
+	events := unsent(t, o, longAgo)
+	if len(events) != 1 || events[0].Before == nil || events[0].After == nil {
+		t.Fatalf("Expected the event with both snapshots, got %+v", events)
+	}
+
+	b := events[0].Before
+	if b.Id != "1" || b.Name != e.Before.Name || b.Brand != e.Before.Brand || b.Price != 800 || b.Category != e.Before.Category || b.Image != e.Before.Image || b.Rating != e.Before.Rating {
+		t.Errorf("Expected the before snapshot, got %+v", b)
+	}
+	if events[0].After.Price != 750 {
+		t.Errorf("Expected the after snapshot, got %+v", events[0].After)
+	}
+}
+
+func testOutboxNoSnapshot(t *testing.T, o repository.Outbox) {
+	e := event(model.EventProductDeleted, "1", time.Now())
+	add(t, o, e)
+	_ = o.Ready(context.Background(), e.Id)
+
+	events := unsent(t, o, longAgo)
+	if len(events) != 1 || events[0].Before != nil || events[0].After != nil {
+		t.Errorf("Expected the event without snapshots, got %+v", events)
+	}
+}
+
+func testOutboxPendingGrace(t *testing.T, o repository.Outbox) {
+	old := event(model.EventProductDeleted, "1", time.Now().Add(-time.Minute))
+	recent := event(model.EventProductDeleted, "2", time.Now())
+	add(t, o, old, recent)
+
+	events := unsent(t, o, time.Now().Add(-time.Second))
+	if len(events) != 1 || events[0].Id != old.Id {
+		t.Errorf("Expected only the pending event past the grace period, got %+v", events)
+	}
+}
+
+func testOutboxRemove(t *testing.T, o repository.Outbox) {
+	e := event(model.EventProductUpdated, "1", time.Now().Add(-time.Minute))
+	add(t, o, e)
+
+	if err := o.Remove(context.Background(), e.Id, "missing"); err != nil {
+		t.Fatalf("Expected events to be removed, got %s", err)
+	}
+
+	if events := unsent(t, o, time.Now()); len(events) != 0 {
+		t.Errorf("Expected no events, got %+v", events)
+	}
+}
+
+func testOutboxSent(t *testing.T, o repository.Outbox) {
+	e := event(model.EventProductUpdated, "1", time.Now())
+	add(t, o, e)
+	_ = o.Ready(context.Background(), e.Id)
+
+	if err := o.Sent(context.Background(), e.Id); err != nil {
+		t.Fatalf("Expected event to be sent, got %s", err)
+	}
+
+	if events := unsent(t, o, time.Now()); len(events) != 0 {
+		t.Errorf("Expected sent events not to be unsent, got %+v", events)
+	}
+}
+
+func testOutboxFailed(t *testing.T, o repository.Outbox) {
+	e := event(model.EventProductUpdated, "1", time.Now())
+	add(t, o, e)
+	_ = o.Ready(context.Background(), e.Id)
+
+	for i := 0; i < 2; i++ {
+		if err := o.Failed(context.Background(), e.Id, errors.New("broker down")); err != nil {
+			t.Fatalf("Expected failed attempt to be counted, got %s", err)
+		}
+	}
+
+	events := unsent(t, o, longAgo)
+	if len(events) != 1 || events[0].Attempts != 2 {
+		t.Errorf("Expected the event with 2 attempts, got %+v", events)
+	}
+}
+
+func testOutboxPark(t *testing.T, o repository.Outbox) {
+	parked := event(model.EventProductUpdated, "1", time.Now().Add(-time.Millisecond))
+	ready := event(model.EventProductUpdated, "2", time.Now())
+	add(t, o, parked, ready)
+	_ = o.Ready(context.Background(), parked.Id, ready.Id)
+
+	if err := o.Park(context.Background(), parked.Id, errors.New("unroutable")); err != nil {
+		t.Fatalf("Expected event to be parked, got %s", err)
+	}
+
+	events := unsent(t, o, longAgo)
+	if len(events) != 1 || events[0].Id != ready.Id {
+		t.Errorf("Expected only the ready event to be unsent, got %+v", events)
+	}
+}
+
+func testOutboxPurge(t *testing.T, o repository.Outbox) {
+	sent := event(model.EventProductUpdated, "1", time.Now())
+	ready := event(model.EventProductUpdated, "2", time.Now())
+	add(t, o, sent, ready)
+	_ = o.Ready(context.Background(), sent.Id, ready.Id)
+	_ = o.Sent(context.Background(), sent.Id)
+
+	if err := o.Purge(context.Background(), time.Now().Add(time.Minute)); err != nil {
+		t.Fatalf("Expected sent events to be purged, got %s", err)
+	}
+
+	events := unsent(t, o, longAgo)
+	if len(events) != 1 || events[0].Id != ready.Id {
+		t.Errorf("Expected only the ready event to be left, got %+v", events)
+	}
+}
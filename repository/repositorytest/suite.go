@@ -0,0 +1,368 @@
+// Package repositorytest is a conformance suite every repository.Repository implementation must pass
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	myerr "github.com/pejovski/catalog/error"
+	"github.com/pejovski/catalog/model"
+	"github.com/pejovski/catalog/repository"
+)
+
+// Run runs the suite, newRepository must return an empty repository on every call
+func Run(t *testing.T, newRepository func(t *testing.T) repository.Repository) {
+	tests := map[string]func(t *testing.T, r repository.Repository){
+		"CreateGet":          testCreateGet,
+		"GetByIds":           testGetByIds,
+		"NotFound":           testNotFound,
+		"UpdateKeepsRating":  testUpdateKeepsRating,
+		"UpdatePrice":        testUpdatePrice,
+		"VersionConflict":    testVersionConflict,
+		"Delete":             testDelete,
+		"GetByFilterPages":   testGetByFilterPages,
+		"GetByFilterFacets":  testGetByFilterFacets,
+		"GetByFilterInvalid": testGetByFilterInvalidCursor,
+		"Search":             testSearch,
+		"Bulk":               testBulk,
+		"BulkVersion":        testBulkVersion,
+	}
+
+	for name, test := range tests {
+		test := test
+		t.Run(name, func(t *testing.T) {
+			test(t, newRepository(t))
+		})
+	}
+}
+
+func galaxy() *model.Product {
+	return &model.Product{Name: "Galaxy S10", Brand: "Samsung", Price: 800, Category: "phones", Image: "galaxy.jpg"}
+}
+
+func create(t *testing.T, r repository.Repository, p *model.Product) string {
+	id, err := r.Create(context.Background(), p)
+	if err != nil {
+		t.Fatalf("Expected product to be created, got %s", err)
+	}
+	return id
+}
+
+func get(t *testing.T, r repository.Repository, id string) *model.Product {
+	p, err := r.Get(context.Background(), id)
+	if err != nil {
+		t.Fatalf("Expected product %s, got %s", id, err)
+	}
+	return p
+}
+
+func testCreateGet(t *testing.T, r repository.Repository) {
+	in := galaxy()
+	in.Id = "client-id"
+	in.Rating = model.Rating{Stars: 5, Customers: 1}
+
+	id := create(t, r, in)
+	if id != in.Id {
+		t.Fatalf("Expected the product to be created as %s, got %q", in.Id, id)
+	}
+	if _, err := r.Create(context.Background(), in); !errors.Is(err, myerr.ErrConflict) {
+		t.Errorf("Expected a conflict when creating %s again, got %v", in.Id, err)
+	}
+	if generated := create(t, r, galaxy()); generated == "" || generated == id {
+		t.Errorf("Expected a generated id, got %q", generated)
+	}
+
+	p := get(t, r, id)
+
+	if p.Id != id || p.Name != in.Name || p.Brand != in.Brand || p.Price != in.Price || p.Category != in.Category || p.Image != in.Image {
+		t.Errorf("Expected the created product, got %+v", p)
+	}
+	if p.Stars != 0 || p.Customers != 0 {
+		t.Error("Expected the rating not to be set on create")
+	}
+	if p.Version == nil {
+		t.Error("Expected a version")
+	}
+}
+
+func testGetByIds(t *testing.T, r repository.Repository) {
+	first, second := create(t, r, galaxy()), create(t, r, galaxy())
+
+	ps, err := r.GetByIds(context.Background(), []string{second, "missing", first})
+	if err != nil {
+		t.Fatalf("Expected products, got %s", err)
+	}
+
+	if len(ps) != 2 || ps[0].Id != second || ps[1].Id != first {
+		t.Fatalf("Expected products %s and %s, got %+v", second, first, ps)
+	}
+	if ps[0].Version == nil {
+		t.Error("Expected a version")
+	}
+
+	if ps, err := r.GetByIds(context.Background(), nil); err != nil || len(ps) != 0 {
+		t.Errorf("Expected no products, got %+v %v", ps, err)
+	}
+}
+
+func testNotFound(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+
+	if _, err := r.Get(ctx, "missing"); !errors.Is(err, myerr.ErrNotFound) {
+		t.Errorf("Expected not found on get, got %v", err)
+	}
+	if err := r.Update(ctx, &model.Product{Id: "missing"}); !errors.Is(err, myerr.ErrNotFound) {
+		t.Errorf("Expected not found on update, got %v", err)
+	}
+	if err := r.UpdatePrice(ctx, "missing", 1, nil); !errors.Is(err, myerr.ErrNotFound) {
+		t.Errorf("Expected not found on price update, got %v", err)
+	}
+	if err := r.UpdateRating(ctx, "missing", &model.Rating{}); !errors.Is(err, myerr.ErrNotFound) {
+		t.Errorf("Expected not found on rating update, got %v", err)
+	}
+	if err := r.Delete(ctx, "missing"); !errors.Is(err, myerr.ErrNotFound) {
+		t.Errorf("Expected not found on delete, got %v", err)
+	}
+}
+
+func testUpdateKeepsRating(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	id := create(t, r, galaxy())
+
+	if err := r.UpdateRating(ctx, id, &model.Rating{Stars: 4.5, Customers: 10}); err != nil {
+		t.Fatalf("Expected rating update, got %s", err)
+	}
+
+	u := galaxy()
+	u.Id = id
+	u.Name = "Galaxy S20"
+	if err := r.Update(ctx, u); err != nil {
+		t.Fatalf("Expected update, got %s", err)
+	}
+
+	p := get(t, r, id)
+	if p.Name != "Galaxy S20" {
+		t.Errorf("Expected name to be updated, got %s", p.Name)
+	}
+	if p.Stars != 4.5 || p.Customers != 10 {
+		t.Errorf("Expected rating to be kept, got %v from %d", p.Stars, p.Customers)
+	}
+}
+
+func testUpdatePrice(t *testing.T, r repository.Repository) {
+	id := create(t, r, galaxy())
+
+	if err := r.UpdatePrice(context.Background(), id, 700, nil); err != nil {
+		t.Fatalf("Expected price update, got %s", err)
+	}
+
+	p := get(t, r, id)
+	if p.Price != 700 || p.Name != galaxy().Name {
+		t.Errorf("Expected only the price to change, got %+v", p)
+	}
+}
+
+func testVersionConflict(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	id := create(t, r, galaxy())
+
+	stale := get(t, r, id)
+
+	if err := r.UpdatePrice(ctx, id, 700, stale.Version); err != nil {
+		t.Fatalf("Expected update with the current version, got %s", err)
+	}
+
+	if err := r.UpdatePrice(ctx, id, 600, stale.Version); !errors.Is(err, myerr.ErrConflict) {
+		t.Errorf("Expected conflict on price update, got %v", err)
+	}
+
+	if err := r.Update(ctx, stale); !errors.Is(err, myerr.ErrConflict) {
+		t.Errorf("Expected conflict on update, got %v", err)
+	}
+}
+
+func testDelete(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	id := create(t, r, galaxy())
+
+	if err := r.Delete(ctx, id); err != nil {
+		t.Fatalf("Expected delete, got %s", err)
+	}
+
+	if _, err := r.Get(ctx, id); !errors.Is(err, myerr.ErrNotFound) {
+		t.Errorf("Expected deleted product not to be found, got %v", err)
+	}
+}
+
+func testGetByFilterPages(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+
+	ids := map[string]bool{}
+	for i := 0; i < 3; i++ {
+		ids[create(t, r, galaxy())] = true
+	}
+	tv := galaxy()
+	tv.Category = "tvs"
+	create(t, r, tv)
+
+	f := model.Filter{Categories: []string{"phones"}}
+
+	first, err := r.GetByFilter(ctx, f, model.Page{Limit: 2})
+	if err != nil {
+		t.Fatalf("Expected first page, got %s", err)
+	}
+	if len(first.Products) != 2 || first.NextCursor == "" || first.Total != 3 {
+		t.Fatalf("Expected 2 of 3 products and a cursor, got %d of %d", len(first.Products), first.Total)
+	}
+
+	second, err := r.GetByFilter(ctx, f, model.Page{Limit: 2, Cursor: first.NextCursor})
+	if err != nil {
+		t.Fatalf("Expected second page, got %s", err)
+	}
+	if len(second.Products) != 1 || second.NextCursor != "" {
+		t.Fatalf("Expected the last product without a cursor, got %d", len(second.Products))
+	}
+
+	for _, p := range append(first.Products, second.Products...) {
+		if !ids[p.Id] {
+			t.Errorf("Expected a phone once, got %s", p.Id)
+		}
+		delete(ids, p.Id)
+	}
+
+	all, err := r.GetByFilter(ctx, model.Filter{}, model.Page{Limit: 10})
+	if err != nil || all.Total != 4 {
+		t.Errorf("Expected all 4 products without a filter, got %v", err)
+	}
+}
+
+func testGetByFilterFacets(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+
+	create(t, r, galaxy())
+	iphone := galaxy()
+	iphone.Brand, iphone.Price = "Apple", 1200
+	create(t, r, iphone)
+	tv := galaxy()
+	tv.Category, tv.Price = "tvs", 400
+	create(t, r, tv)
+
+	p, err := r.GetByFilter(ctx, model.Filter{Brands: []string{"Apple"}}, model.Page{Limit: 10})
+	if err != nil {
+		t.Fatalf("Expected products, got %s", err)
+	}
+	if p.Total != 1 {
+		t.Errorf("Expected 1 Apple product, got %d", p.Total)
+	}
+
+	// the brand facet ignores its own selection
+	brands := buckets(p.Facets.Brands)
+	if brands["Samsung"] != 2 || brands["Apple"] != 1 {
+		t.Errorf("Expected multi-select brand counts, got %v", brands)
+	}
+
+	categories := buckets(p.Facets.Categories)
+	if categories["phones"] != 1 || categories["tvs"] != 0 {
+		t.Errorf("Expected category counts of Apple products, got %v", categories)
+	}
+
+	prices := buckets(p.Facets.Prices)
+	if prices["1000-*"] != 1 || prices["100-500"] != 0 {
+		t.Errorf("Expected price counts of Apple products, got %v", prices)
+	}
+}
+
+func buckets(bs []*model.Bucket) map[string]int {
+	m := map[string]int{}
+	for _, b := range bs {
+		m[b.Key] = b.Count
+	}
+	return m
+}
+
+func testGetByFilterInvalidCursor(t *testing.T, r repository.Repository) {
+	_, err := r.GetByFilter(context.Background(), model.Filter{}, model.Page{Limit: 10, Cursor: "%%%"})
+	if !errors.Is(err, myerr.ErrValidation) {
+		t.Errorf("Expected validation error for an invalid cursor, got %v", err)
+	}
+}
+
+func testSearch(t *testing.T, r repository.Repository) {
+	id := create(t, r, galaxy())
+	tv := galaxy()
+	tv.Name, tv.Category = "Smart TV", "tvs"
+	create(t, r, tv)
+
+	sr, err := r.Search(context.Background(), "galaxy", model.Filter{})
+	if err != nil {
+		t.Fatalf("Expected search result, got %s", err)
+	}
+
+	if sr.Total != 1 || len(sr.Hits) != 1 || sr.Hits[0].Product.Id != id {
+		t.Fatalf("Expected only the Galaxy to match, got %d hits", sr.Total)
+	}
+
+	if len(sr.Hits[0].Highlights["name"]) == 0 {
+		t.Error("Expected the name to be highlighted")
+	}
+}
+
+func testBulk(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	id := create(t, r, galaxy())
+
+	u := galaxy()
+	u.Id, u.Name = id, "Galaxy S20"
+
+	created := galaxy()
+	created.Id = "bulk-id"
+
+	rs, err := r.Bulk(ctx, []*model.BulkOperation{
+		{Type: model.OpCreate, Product: created},
+		{Type: model.OpUpdate, Product: u},
+		{Type: model.OpDelete, Product: &model.Product{Id: "missing"}},
+	})
+	if err != nil {
+		t.Fatalf("Expected bulk results, got %s", err)
+	}
+
+	if len(rs) != 3 {
+		t.Fatalf("Expected 3 results, got %d", len(rs))
+	}
+	if rs[0].Error != "" || rs[0].Id != created.Id {
+		t.Errorf("Expected create with the given id, got %+v", rs[0])
+	}
+	if rs[1].Error != "" || get(t, r, id).Name != "Galaxy S20" {
+		t.Errorf("Expected update, got %+v", rs[1])
+	}
+	if rs[2].Error == "" {
+		t.Error("Expected delete of a missing product to fail")
+	}
+}
+
+func testBulkVersion(t *testing.T, r repository.Repository) {
+	ctx := context.Background()
+	updated, deleted := create(t, r, galaxy()), create(t, r, galaxy())
+	stale := get(t, r, updated).Version
+	_ = r.UpdatePrice(ctx, updated, 790, nil)
+
+	u := galaxy()
+	u.Id, u.Version = updated, stale
+
+	rs, err := r.Bulk(ctx, []*model.BulkOperation{
+		{Type: model.OpUpdate, Product: u},
+		{Type: model.OpDelete, Product: &model.Product{Id: deleted, Version: get(t, r, deleted).Version}},
+	})
+	if err != nil {
+		t.Fatalf("Expected bulk results, got %s", err)
+	}
+
+	if rs[0].Status != http.StatusConflict || get(t, r, updated).Price != 790 {
+		t.Errorf("Expected update with a stale version to conflict, got %+v", rs[0])
+	}
+	if rs[1].Error != "" {
+		t.Errorf("Expected delete with the current version, got %+v", rs[1])
+	}
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	myerr "github.com/pejovski/catalog/error"
+	"github.com/pejovski/catalog/pkg/rabbitmq"
+	amqpReceiver "github.com/pejovski/catalog/receiver/amqp"
+)
+
+const (
+	defaultDeadLetterLimit = 100
+	maxDeadLetterLimit     = 1000
+)
+
+// AdminHandler serves the operations on the dead-letter queues, the routes must not be exposed publicly
+type AdminHandler interface {
+	DeadLetters() http.HandlerFunc
+	ReplayDeadLetters() http.HandlerFunc
+	PurgeDeadLetters() http.HandlerFunc
+}
+
+type adminHandler struct {
+	handler
+	deadLetters amqpReceiver.DeadLetters
+}
+
+func newAdminHandler(dl amqpReceiver.DeadLetters) AdminHandler {
+	return adminHandler{handler: handler{mapper: newMapper()}, deadLetters: dl}
+}
+
+func (h adminHandler) DeadLetters() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		limit := defaultDeadLetterLimit
+		if l := r.FormValue("limit"); l != "" {
+			var err error
+			limit, err = strconv.Atoi(l)
+			if err != nil || limit < 1 || limit > maxDeadLetterLimit {
+				h.fail(w, r, myerr.New(myerr.ErrValidation, fmt.Sprintf("Limit must be between 1 and %d", maxDeadLetterLimit)))
+				return
+			}
+		}
+
+		dls, err := h.deadLetters.List(limit)
+		if err != nil {
+			h.fail(w, r, brokerError(err))
+			return
+		}
+
+		h.respond(w, r, h.mapper.mapDeadLettersToDeadLetters(dls), http.StatusOK)
+	}
+}
+
+func (h adminHandler) ReplayDeadLetters() http.HandlerFunc {
+	return h.take(h.deadLetters.Replay)
+}
+
+func (h adminHandler) PurgeDeadLetters() http.HandlerFunc {
+	return h.take(h.deadLetters.Purge)
+}
+
+// take applies the operation to the messages of the request, or to all of them on an empty body
+func (h adminHandler) take(op func(ids []string) (int, error)) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var sel DeadLetterSelection
+		if err := h.decode(w, r, &sel); err != nil && err != io.EOF {
+			h.fail(w, r, myerr.New(myerr.ErrValidation, "Invalid request body"))
+			return
+		}
+		if sel.Ids != nil && len(sel.Ids) == 0 {
+			h.fail(w, r, myerr.New(myerr.ErrValidation, "Ids must not be empty, leave them out to select all messages"))
+			return
+		}
+
+		n, err := op(sel.Ids)
+		if err != nil {
+			h.fail(w, r, brokerError(err))
+			return
+		}
+
+		h.respond(w, r, &DeadLetterResult{Count: n}, http.StatusOK)
+	}
+}
+
+func brokerError(err error) error {
+	if errors.Is(err, rabbitmq.ErrNotConnected) {
+		return myerr.Wrap(myerr.ErrUnavailable, err)
+	}
+	return err
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/pejovski/catalog/pkg/rabbitmq"
+	amqpReceiver "github.com/pejovski/catalog/receiver/amqp"
+)
+
+type fakeDeadLetters struct {
+	err error
+	ids []string
+}
+
+func (f *fakeDeadLetters) List(limit int) ([]*amqpReceiver.DeadLetter, error) {
+	return []*amqpReceiver.DeadLetter{{Id: "1", Reason: "boom", Body: []byte{0xff}}}, f.err
+}
+
+func (f *fakeDeadLetters) Replay(ids []string) (int, error) {
+	f.ids = ids
+	return len(ids), f.err
+}
+
+func (f *fakeDeadLetters) Purge(ids []string) (int, error) {
+	f.ids = ids
+	return 3, f.err
+}
+
+func TestDeadLetters(t *testing.T) {
+	w := httptest.NewRecorder()
+	newAdminHandler(&fakeDeadLetters{}).DeadLetters()(w, httptest.NewRequest("GET", "/admin/dlq", nil))
+
+	var dls []*DeadLetter
+	if err := json.NewDecoder(w.Body).Decode(&dls); err != nil {
+		t.Fatalf("Failed to decode dead letters: %s", err)
+	}
+	if len(dls) != 1 || dls[0].Reason != "boom" || dls[0].Body != "ff" {
+		t.Errorf("Unexpected dead letters %+v", dls)
+	}
+
+	w = httptest.NewRecorder()
+	newAdminHandler(&fakeDeadLetters{err: rabbitmq.ErrNotConnected}).DeadLetters()(w, httptest.NewRequest("GET", "/admin/dlq", nil))
+	if w.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected status code %d while disconnected, got %d", http.StatusServiceUnavailable, w.Code)
+	}
+}
+
+func TestReplayDeadLetters(t *testing.T) {
+	tests := []struct {
+		body   string
+		status int
+		ids    []string
+	}{
+		{"", http.StatusOK, nil},
+		{`{"ids":["1","2"]}`, http.StatusOK, []string{"1", "2"}},
+		{`{"ids":[]}`, http.StatusBadRequest, nil},
+		{`{"ids":`, http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		dl := &fakeDeadLetters{}
+		w := httptest.NewRecorder()
+		newAdminHandler(dl).ReplayDeadLetters()(w, httptest.NewRequest("POST", "/admin/dlq/replay", strings.NewReader(tt.body)))
+
+		if w.Code != tt.status {
+			t.Errorf("Expected status code %d for %q, got %d", tt.status, tt.body, w.Code)
+		}
+		if !reflect.DeepEqual(dl.ids, tt.ids) {
+			t.Errorf("Expected ids %v for %q, got %v", tt.ids, tt.body, dl.ids)
+		}
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	w := httptest.NewRecorder()
+	newRouter(nil, nil).ServeHTTP(w, httptest.NewRequest("POST", "/admin/dlq/purge", nil))
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected the api not to serve the admin endpoints, got %d", w.Code)
+	}
+
+	dl := &fakeDeadLetters{}
+	w = httptest.NewRecorder()
+	newAdminRouter(dl).ServeHTTP(w, httptest.NewRequest("POST", "/admin/dlq/purge", nil))
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected the admin router to serve the admin endpoints, got %d", w.Code)
+	}
+}
@@ -1,5 +1,7 @@
 package api
 
+import "time"
+
 type Product struct {
 	Id       string  `json:"id"`
 	Name     string  `json:"name"`
@@ -10,9 +12,97 @@ type Product struct {
 	Rating   Rating  `json:"rating"`
 }
 
+type ProductPage struct {
+	Products []*Product `json:"products"`
+	// pass as cursor to get the next page, empty on the last page
+	NextCursor string  `json:"next_cursor"`
+	Total      int     `json:"total"`
+	Facets     *Facets `json:"facets"`
+}
+
+type Facets struct {
+	Categories []*Bucket `json:"categories"`
+	Brands     []*Bucket `json:"brands"`
+	Prices     []*Bucket `json:"prices"`
+	Ratings    []*Bucket `json:"ratings"`
+}
+
+type Bucket struct {
+	Key      string `json:"key"`
+	Count    int    `json:"count"`
+	Selected bool   `json:"selected"`
+}
+
 type Rating struct {
 	// out of 5 (e.g. 3.9)
 	Stars float32 `json:"stars"`
 	// number of customers who reviewed the product
 	Customers int `json:"customers"`
 }
+
+type SearchHit struct {
+	Product
+	// highlighted fragments per matched field
+	Highlights map[string][]string `json:"highlights,omitempty"`
+}
+
+type SearchResult struct {
+	Hits   []*SearchHit `json:"hits"`
+	Total  int          `json:"total"`
+	Facets *Facets      `json:"facets"`
+}
+
+// BulkOperation is a line of a NDJSON bulk request or an element of a JSON array one
+type BulkOperation struct {
+	// create, update or delete
+	Op string `json:"op"`
+	// required for update and delete
+	Id      string   `json:"id"`
+	Product *Product `json:"product"`
+}
+
+type BulkReport struct {
+	// true when at least one operation failed
+	Errors bool          `json:"errors"`
+	Items  []*BulkResult `json:"items"`
+}
+
+type BulkResult struct {
+	Op     string `json:"op"`
+	Id     string `json:"id,omitempty"`
+	Status int    `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type Health struct {
+	// up, or degraded when any of the components is unhealthy
+	Status     string                `json:"status"`
+	Components map[string]*Component `json:"components"`
+}
+
+type Component struct {
+	State   string `json:"state"`
+	Healthy bool   `json:"healthy"`
+}
+
+type DeadLetter struct {
+	Id       string `json:"id"`
+	Queue    string `json:"queue"`
+	Exchange string `json:"exchange"`
+	// error of the last attempt
+	Reason    string                 `json:"reason"`
+	Attempts  int                    `json:"attempts"`
+	Timestamp time.Time              `json:"timestamp"`
+	Headers   map[string]interface{} `json:"headers"`
+	// hex encoded unless it is text
+	Body string `json:"body"`
+}
+
+type DeadLetterSelection struct {
+	// all messages are selected when left out
+	Ids []string `json:"ids"`
+}
+
+type DeadLetterResult struct {
+	Count int `json:"count"`
+}
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/sirupsen/logrus"
+
+	myerr "github.com/pejovski/catalog/error"
+)
+
+type Error struct {
+	// machine readable, e.g. not_found
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// the rejected fields of an invalid_fields error
+	Fields []*FieldError `json:"fields,omitempty"`
+}
+
+type FieldError struct {
+	Field string `json:"field"`
+	// machine readable, e.g. required
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// fail is the single place where errors are translated into a status code and an error body
+func (h handler) fail(w http.ResponseWriter, r *http.Request, err error) {
+	status, code := http.StatusInternalServerError, "internal"
+
+	switch {
+	case errors.Is(err, myerr.ErrNotFound):
+		status, code = http.StatusNotFound, "not_found"
+	case errors.Is(err, myerr.ErrConflict) && sentVersion(r):
+		status, code = http.StatusPreconditionFailed, "precondition_failed"
+	case errors.Is(err, myerr.ErrConflict):
+		status, code = http.StatusConflict, "conflict"
+	case errors.Is(err, myerr.ErrValidation):
+		status, code = http.StatusBadRequest, "validation_failed"
+	case errors.Is(err, myerr.ErrInvalid):
+		status, code = http.StatusUnprocessableEntity, "invalid_fields"
+	case errors.Is(err, myerr.ErrUnavailable):
+		status, code = http.StatusServiceUnavailable, "unavailable"
+	case errors.Is(err, myerr.ErrTimeout):
+		status, code = http.StatusGatewayTimeout, "timeout"
+	}
+
+	message := http.StatusText(status)
+
+	// only validation reasons of the client input are meant for the client, the others may expose internals
+	var e *myerr.Error
+	if status == http.StatusBadRequest && errors.As(err, &e) && e.Reason != "" && e.Status == 0 {
+		message = e.Reason
+	}
+
+	var fields []*FieldError
+	var fe myerr.FieldErrors
+	if errors.As(err, &fe) {
+		for _, f := range fe {
+			fields = append(fields, &FieldError{Field: f.Field, Code: f.Code, Message: f.Message})
+		}
+	}
+
+	if status >= http.StatusInternalServerError {
+		logrus.Errorf("Failed to serve %s %s. Error: %s", r.Method, r.URL.Path, err)
+	} else {
+		logrus.Warnf("Failed to serve %s %s. Error: %s", r.Method, r.URL.Path, err)
+	}
+
+	h.respond(w, r, &Error{Code: code, Message: message, Fields: fields}, status)
+}
+
+// sentVersion tells if the client made the write conditional on a version, an invalid etag counts
+// since it can never match. With "*" the conflict comes from the snapshot of the controller, not the client.
+func sentVersion(r *http.Request) bool {
+	v, err := ifMatch(r)
+	return v != nil || err != nil
+}
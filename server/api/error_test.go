@@ -0,0 +1,54 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	myerr "github.com/pejovski/catalog/error"
+)
+
+func TestFail(t *testing.T) {
+
+	tests := []struct {
+		err     error
+		ifMatch string
+		status  int
+		code    string
+	}{
+		{&myerr.Error{Kind: myerr.ErrNotFound, Status: 404}, "", http.StatusNotFound, "not_found"},
+		{fmt.Errorf("update: %w", myerr.New(myerr.ErrConflict, "")), `"1-2"`, http.StatusPreconditionFailed, "precondition_failed"},
+		{myerr.New(myerr.ErrConflict, ""), "", http.StatusConflict, "conflict"},
+		{myerr.New(myerr.ErrConflict, ""), "*", http.StatusConflict, "conflict"},
+		{myerr.Wrap(myerr.ErrConflict, errInvalidETag), "1-2", http.StatusPreconditionFailed, "precondition_failed"},
+		{myerr.New(myerr.ErrValidation, "Invalid cursor"), "", http.StatusBadRequest, "validation_failed"},
+		{myerr.Invalid(myerr.FieldErrors{{Field: "price", Code: "out_of_range"}}), "", http.StatusUnprocessableEntity, "invalid_fields"},
+		{myerr.Wrap(myerr.ErrUnavailable, errors.New("connection refused")), "", http.StatusServiceUnavailable, "unavailable"},
+		{myerr.Wrap(myerr.ErrTimeout, errors.New("deadline exceeded")), "", http.StatusGatewayTimeout, "timeout"},
+		{errors.New("boom"), "", http.StatusInternalServerError, "internal"},
+		{&myerr.Error{Kind: myerr.ErrInternal, Status: 400, Reason: "parsing_exception: unknown query"}, "", http.StatusInternalServerError, "internal"},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("PUT", "/products/111", nil)
+		if tt.ifMatch != "" {
+			r.Header.Set("If-Match", tt.ifMatch)
+		}
+
+		handler{}.fail(w, r, tt.err)
+
+		var body Error
+		_ = json.NewDecoder(w.Body).Decode(&body)
+
+		if w.Code != tt.status || body.Code != tt.code {
+			t.Errorf("Expected %d %s for %s, got %d %s", tt.status, tt.code, tt.err, w.Code, body.Code)
+		}
+		if tt.status == http.StatusInternalServerError && body.Message != http.StatusText(tt.status) {
+			t.Errorf("Expected the reason of %s to be hidden, got %s", tt.err, body.Message)
+		}
+	}
+}
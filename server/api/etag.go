@@ -0,0 +1,33 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/pejovski/catalog/model"
+)
+
+var errInvalidETag = errors.New("invalid etag")
+
+// etag is a strong entity tag made of the elasticsearch primary term and sequence number
+func etag(v *model.Version) string {
+	return fmt.Sprintf(`"%d-%d"`, v.PrimaryTerm, v.SeqNo)
+}
+
+// ifMatch reads the expected version from the If-Match header, the version is nil
+// when the header is missing or "*" so the write is unconditional
+func ifMatch(r *http.Request) (*model.Version, error) {
+	h := strings.TrimSpace(r.Header.Get("If-Match"))
+	if h == "" || h == "*" {
+		return nil, nil
+	}
+
+	var v model.Version
+	if _, err := fmt.Sscanf(h, `"%d-%d"`, &v.PrimaryTerm, &v.SeqNo); err != nil || etag(&v) != h {
+		return nil, errInvalidETag
+	}
+
+	return &v, nil
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"github.com/pejovski/catalog/model"
+)
+
+func TestIfMatch(t *testing.T) {
+
+	r := httptest.NewRequest("PUT", "/products/111", nil)
+
+	if v, err := ifMatch(r); v != nil || err != nil {
+		t.Error("Expected no version without If-Match")
+	}
+
+	r.Header.Set("If-Match", etag(&model.Version{SeqNo: 12, PrimaryTerm: 3}))
+
+	v, err := ifMatch(r)
+	if err != nil || v.SeqNo != 12 || v.PrimaryTerm != 3 {
+		t.Errorf("Expected version 12/3, got %+v, %v", v, err)
+	}
+
+	for _, h := range []string{`W/"3-12"`, `"3-12"x`, `3-12`, `"a-b"`} {
+		r.Header.Set("If-Match", h)
+		if _, err := ifMatch(r); err != errInvalidETag {
+			t.Errorf("Expected invalid etag for %s, got %v", h, err)
+		}
+	}
+}
@@ -1,9 +1,13 @@
 package api
 
 import (
+	"bufio"
 	"encoding/json"
 	"fmt"
+	"io"
 	"net/http"
+	"strconv"
+	"unicode"
 
 	"github.com/gorilla/mux"
 	"github.com/sirupsen/logrus"
@@ -13,13 +17,24 @@ import (
 	"github.com/pejovski/catalog/model"
 )
 
+const (
+	defaultLimit = 20
+	maxLimit     = 100
+
+	maxBulkOperations = 5000
+	maxBulkBodySize   = 10 << 20
+)
+
 type Handler interface {
 	Products() http.HandlerFunc
+	SearchProducts() http.HandlerFunc
 	Product() http.HandlerFunc
 	CreateProduct() http.HandlerFunc
+	BulkProducts() http.HandlerFunc
 	UpdateProduct() http.HandlerFunc
 	UpdateProductPrice() http.HandlerFunc
 	DeleteProduct() http.HandlerFunc
+	Health(checks map[string]HealthCheck) http.HandlerFunc
 }
 
 type handler struct {
@@ -36,21 +51,54 @@ func newHandler(c controller.Controller) Handler {
 
 func (h handler) Products() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		category := r.FormValue("category")
-		if category == "" {
-			logrus.Warnln("Category not found")
-			http.Error(w, "Category not found", http.StatusBadRequest)
+		f, err := h.filter(r)
+		if err != nil {
+			h.fail(w, r, err)
 			return
 		}
 
-		dps, err := h.controller.GetProducts(category)
+		page := model.Page{Limit: defaultLimit, Cursor: r.FormValue("cursor")}
+
+		if l := r.FormValue("limit"); l != "" {
+			limit, err := strconv.Atoi(l)
+			if err != nil || limit < 1 || limit > maxLimit {
+				h.fail(w, r, myerr.New(myerr.ErrValidation, fmt.Sprintf("Limit must be between 1 and %d", maxLimit)))
+				return
+			}
+			page.Limit = limit
+		}
+
+		dp, err := h.controller.GetProducts(r.Context(), f, page)
 		if err != nil {
-			logrus.Errorf("Failed to get products for category %s. Error: %s", category, err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			h.fail(w, r, err)
 			return
 		}
 
-		h.respond(w, r, h.mapper.mapDomainProductsToProducts(dps), http.StatusOK)
+		h.respond(w, r, h.mapper.mapDomainProductPageToProductPage(dp), http.StatusOK)
+	}
+}
+
+func (h handler) SearchProducts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		q := r.FormValue("q")
+		if q == "" {
+			h.fail(w, r, myerr.New(myerr.ErrValidation, "Search query not found"))
+			return
+		}
+
+		f, err := h.filter(r)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		dr, err := h.controller.SearchProducts(r.Context(), q, f)
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, h.mapper.mapDomainSearchResultToSearchResult(dr), http.StatusOK)
 	}
 }
 
@@ -60,22 +108,20 @@ func (h handler) Product() http.HandlerFunc {
 
 		id := params["id"]
 		if id == "" {
-			logrus.Warnln("Product id not found")
-			http.Error(w, "Product id not found", http.StatusBadRequest)
+			h.fail(w, r, myerr.New(myerr.ErrValidation, "Product id not found"))
 			return
 		}
 
-		p, err := h.controller.GetProduct(id)
+		p, err := h.controller.GetProduct(r.Context(), id)
 		if err != nil {
-			if err == myerr.ErrNotFound {
-				http.Error(w, "Product not found", http.StatusNotFound)
-				return
-			}
-			logrus.Errorf("Failed to get product with id %s. Error: %s", id, err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			h.fail(w, r, err)
 			return
 		}
 
+		if p.Version != nil {
+			w.Header().Set("ETag", etag(p.Version))
+		}
+
 		h.respond(w, r, h.mapper.mapDomainProductToProduct(p), http.StatusOK)
 	}
 }
@@ -84,16 +130,14 @@ func (h handler) CreateProduct() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		var p *model.Product
-		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
-			logrus.Warnln("Failed to decode request body")
-			http.Error(w, "Bad request", http.StatusBadRequest)
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil || p == nil {
+			h.fail(w, r, myerr.New(myerr.ErrValidation, "Invalid request body"))
 			return
 		}
 
-		id, err := h.controller.CreateProduct(p)
+		id, err := h.controller.CreateProduct(r.Context(), p)
 		if err != nil {
-			logrus.Errorf("Failed to create product for id %s. Error: %s", p.Id, err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+			h.fail(w, r, err)
 			return
 		}
 
@@ -102,29 +146,98 @@ func (h handler) CreateProduct() http.HandlerFunc {
 	}
 }
 
+func (h handler) BulkProducts() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+
+		ops, err := h.decodeBulkOperations(http.MaxBytesReader(w, r.Body, maxBulkBodySize))
+		if err != nil {
+			h.fail(w, r, myerr.New(myerr.ErrValidation, "Invalid request body"))
+			return
+		}
+
+		if len(ops) == 0 || len(ops) > maxBulkOperations {
+			h.fail(w, r, myerr.New(myerr.ErrValidation, fmt.Sprintf("Between 1 and %d operations are allowed", maxBulkOperations)))
+			return
+		}
+
+		drs, err := h.controller.BulkProducts(r.Context(), h.mapper.mapBulkOperationsToDomainBulkOperations(ops))
+		if err != nil {
+			h.fail(w, r, err)
+			return
+		}
+
+		h.respond(w, r, h.mapper.mapDomainBulkResultsToBulkReport(drs), http.StatusOK)
+	}
+}
+
+// decodeBulkOperations accepts both a JSON array and NDJSON (one operation per line)
+func (h handler) decodeBulkOperations(body io.Reader) ([]*BulkOperation, error) {
+	br := bufio.NewReader(body)
+
+	// the first non-space character tells the format apart
+	for {
+		b, err := br.Peek(1)
+		if err != nil {
+			return nil, err
+		}
+		if !unicode.IsSpace(rune(b[0])) {
+			break
+		}
+		_, _ = br.ReadByte()
+	}
+
+	ops := []*BulkOperation{}
+	dec := json.NewDecoder(br)
+
+	if b, _ := br.Peek(1); b[0] == '[' {
+		err := dec.Decode(&ops)
+		return ops, err
+	}
+
+	for dec.More() {
+		var op *BulkOperation
+		if err := dec.Decode(&op); err != nil {
+			return nil, err
+		}
+		ops = append(ops, op)
+	}
+
+	return ops, nil
+}
+
 func (h handler) UpdateProduct() http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
 		var p *model.Product
-		if err := json.NewDecoder(r.Body).Decode(&p); err != nil {
-			logrus.Warnln("Failed to decode request body")
-			http.Error(w, "Bad request", http.StatusBadRequest)
+		if err := json.NewDecoder(r.Body).Decode(&p); err != nil || p == nil {
+			h.fail(w, r, myerr.New(myerr.ErrValidation, "Invalid request body"))
 			return
 		}
 
 		params := mux.Vars(r)
 		id := params["id"]
 		if id == "" {
-			logrus.Warnln("Product id not found")
-			http.Error(w, "Product id not found", http.StatusBadRequest)
+			h.fail(w, r, myerr.New(myerr.ErrValidation, "Product id not found"))
+			return
+		}
+
+		// the id of the product is taken from the path, a different one in the body is a mistake
+		if p.Id != "" && p.Id != id {
+			h.fail(w, r, myerr.Invalid(myerr.FieldErrors{{Field: "id", Code: "read_only", Message: "The id cannot be changed"}}))
+			return
+		}
+
+		v, err := ifMatch(r)
+		if err != nil {
+			h.fail(w, r, myerr.Wrap(myerr.ErrConflict, err))
 			return
 		}
 
 		p.Id = id
+		p.Version = v
 
-		if err := h.controller.UpdateProduct(p); err != nil {
-			logrus.Errorf("Failed to update product for id %s. Error: %s", p.Id, err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		if err := h.controller.UpdateProduct(r.Context(), p); err != nil {
+			h.fail(w, r, err)
 			return
 		}
 
@@ -133,29 +246,36 @@ func (h handler) UpdateProduct() http.HandlerFunc {
 }
 
 func (h handler) UpdateProductPrice() http.HandlerFunc {
-
-	var request struct {
-		Price float32 `json:"price"`
-	}
-
 	return func(w http.ResponseWriter, r *http.Request) {
+		var request struct {
+			// nil when the price is missing
+			Price *float32 `json:"price"`
+		}
+
 		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
-			logrus.Warnln("Failed to decode request body")
-			http.Error(w, "Bad request", http.StatusBadRequest)
+			h.fail(w, r, myerr.New(myerr.ErrValidation, "Invalid request body"))
+			return
+		}
+		if request.Price == nil {
+			h.fail(w, r, myerr.Invalid(myerr.FieldErrors{{Field: "price", Code: "required", Message: "The price is required"}}))
 			return
 		}
 
 		params := mux.Vars(r)
 		id := params["id"]
 		if id == "" {
-			logrus.Warnln("Product id not found")
-			http.Error(w, "Product id not found", http.StatusBadRequest)
+			h.fail(w, r, myerr.New(myerr.ErrValidation, "Product id not found"))
 			return
 		}
 
-		if err := h.controller.UpdateProductPrice(id, request.Price); err != nil {
-			logrus.Errorf("Failed to update product price for product %s. Error: %s", id, err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		v, err := ifMatch(r)
+		if err != nil {
+			h.fail(w, r, myerr.Wrap(myerr.ErrConflict, err))
+			return
+		}
+
+		if err := h.controller.UpdateProductPrice(r.Context(), id, *request.Price, v); err != nil {
+			h.fail(w, r, err)
 			return
 		}
 
@@ -169,14 +289,12 @@ func (h handler) DeleteProduct() http.HandlerFunc {
 		params := mux.Vars(r)
 		id := params["id"]
 		if id == "" {
-			logrus.Warnln("Product id not found")
-			http.Error(w, "Product id not found", http.StatusBadRequest)
+			h.fail(w, r, myerr.New(myerr.ErrValidation, "Product id not found"))
 			return
 		}
 
-		if err := h.controller.DeleteProduct(id); err != nil {
-			logrus.Errorf("Failed to delete product %s. Error: %s", id, err)
-			http.Error(w, "Internal server error", http.StatusInternalServerError)
+		if err := h.controller.DeleteProduct(r.Context(), id); err != nil {
+			h.fail(w, r, err)
 			return
 		}
 
@@ -184,6 +302,32 @@ func (h handler) DeleteProduct() http.HandlerFunc {
 	}
 }
 
+// filter reads the selected facet values, every facet parameter can be repeated
+func (h handler) filter(r *http.Request) (model.Filter, error) {
+	q := r.URL.Query()
+
+	f := model.Filter{
+		Categories: q["category"],
+		Brands:     q["brand"],
+		Prices:     q["price"],
+		Ratings:    q["rating"],
+	}
+
+	for _, p := range f.Prices {
+		if _, ok := model.FindRange(model.PriceRanges, p); !ok {
+			return f, myerr.New(myerr.ErrValidation, fmt.Sprintf("Unknown price range %s", p))
+		}
+	}
+
+	for _, rt := range f.Ratings {
+		if _, ok := model.FindRange(model.RatingRanges, rt); !ok {
+			return f, myerr.New(myerr.ErrValidation, fmt.Sprintf("Unknown rating range %s", rt))
+		}
+	}
+
+	return f, nil
+}
+
 func (h handler) respond(w http.ResponseWriter, r *http.Request, data interface{}, status int) {
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(status)
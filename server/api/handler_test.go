@@ -0,0 +1,64 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/mux"
+
+	"github.com/pejovski/catalog/controller"
+	"github.com/pejovski/catalog/model"
+	"github.com/pejovski/catalog/repository/memory"
+)
+
+func TestDecodeBulkOperations(t *testing.T) {
+
+	bodies := map[string]string{
+		"array":  ` [{"op": "create", "product": {"name": "Galaxy"}}, {"op": "delete", "id": "111"}]`,
+		"ndjson": "{\"op\": \"create\", \"product\": {\"name\": \"Galaxy\"}}\n{\"op\": \"delete\", \"id\": \"111\"}\n",
+	}
+
+	for format, body := range bodies {
+		ops, err := handler{}.decodeBulkOperations(strings.NewReader(body))
+		if err != nil {
+			t.Fatalf("Expected %s to decode, got %s", format, err)
+		}
+
+		if len(ops) != 2 || ops[0].Product.Name != "Galaxy" || ops[1].Id != "111" {
+			t.Errorf("Expected create and delete operations from %s, got %+v", format, ops)
+		}
+	}
+}
+
+func TestUpdateProductPriceRequired(t *testing.T) {
+	ctx := context.Background()
+	r := memory.NewRepository()
+	id, _ := r.Create(ctx, &model.Product{Name: "Galaxy S10", Brand: "Samsung", Price: 800, Category: "phones"})
+	h := newHandler(controller.New(r, memory.NewOutbox(), nil, controller.NewValidator(nil))).UpdateProductPrice()
+
+	tests := []struct {
+		body   string
+		status int
+	}{
+		{`{"price": 750}`, http.StatusNoContent},
+		// the price of the previous request is not reused
+		{`{}`, http.StatusUnprocessableEntity},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		h(w, mux.SetURLVars(httptest.NewRequest("PATCH", "/products/"+id, strings.NewReader(tt.body)), map[string]string{"id": id}))
+
+		if w.Code != tt.status {
+			t.Errorf("Expected status code %d for %s, got %d", tt.status, tt.body, w.Code)
+		}
+	}
+
+	p, _ := r.Get(ctx, id)
+	if p.Price != 750 {
+		t.Errorf("Expected the price to be 750, got %v", p.Price)
+	}
+}
@@ -0,0 +1,31 @@
+package api
+
+import (
+	"net/http"
+)
+
+const (
+	healthUp       = "up"
+	healthDegraded = "degraded"
+)
+
+// HealthCheck reports the state of a dependency, e.g. connected, healthy is false while it is unusable
+type HealthCheck func() (state string, healthy bool)
+
+// Health responds with 200 even when a dependency is down, the products can still be read
+// and the writes are published once it is back, so the instance must not be restarted
+func (h handler) Health(checks map[string]HealthCheck) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		res := &Health{Status: healthUp, Components: map[string]*Component{}}
+
+		for name, check := range checks {
+			state, healthy := check()
+			res.Components[name] = &Component{State: state, Healthy: healthy}
+			if !healthy {
+				res.Status = healthDegraded
+			}
+		}
+
+		h.respond(w, r, res, http.StatusOK)
+	}
+}
@@ -0,0 +1,41 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHealth(t *testing.T) {
+	tests := []struct {
+		checks map[string]HealthCheck
+		status string
+	}{
+		{nil, healthUp},
+		{map[string]HealthCheck{"rabbitmq": func() (string, bool) { return "connected", true }}, healthUp},
+		{map[string]HealthCheck{"rabbitmq": func() (string, bool) { return "disconnected", false }}, healthDegraded},
+	}
+
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		handler{}.Health(tt.checks)(w, httptest.NewRequest("GET", "/health", nil))
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status code %d, got %d", http.StatusOK, w.Code)
+		}
+
+		var res Health
+		if err := json.NewDecoder(w.Body).Decode(&res); err != nil {
+			t.Fatalf("Failed to decode health: %s", err)
+		}
+		if res.Status != tt.status {
+			t.Errorf("Expected status %s, got %s", tt.status, res.Status)
+		}
+		for name := range tt.checks {
+			if res.Components[name] == nil {
+				t.Errorf("Missing component %s", name)
+			}
+		}
+	}
+}
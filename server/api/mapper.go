@@ -1,12 +1,21 @@
 package api
 
 import (
+	"fmt"
+	"unicode/utf8"
+
 	"github.com/pejovski/catalog/model"
+	amqpReceiver "github.com/pejovski/catalog/receiver/amqp"
 )
 
 type Mapper interface {
 	mapDomainProductToProduct(dp *model.Product) *Product
 	mapDomainProductsToProducts(dps []*model.Product) []*Product
+	mapDomainProductPageToProductPage(dp *model.ProductPage) *ProductPage
+	mapDomainSearchResultToSearchResult(dr *model.SearchResult) *SearchResult
+	mapBulkOperationsToDomainBulkOperations(ops []*BulkOperation) []*model.BulkOperation
+	mapDomainBulkResultsToBulkReport(drs []*model.BulkResult) *BulkReport
+	mapDeadLettersToDeadLetters(dls []*amqpReceiver.DeadLetter) []*DeadLetter
 }
 
 type mapper struct {
@@ -38,3 +47,121 @@ func (m mapper) mapDomainProductsToProducts(dps []*model.Product) []*Product {
 	}
 	return ps
 }
+
+func (m mapper) mapDomainProductPageToProductPage(dp *model.ProductPage) *ProductPage {
+	return &ProductPage{
+		Products:   m.mapDomainProductsToProducts(dp.Products),
+		NextCursor: dp.NextCursor,
+		Total:      dp.Total,
+		Facets:     m.mapDomainFacetsToFacets(dp.Facets),
+	}
+}
+
+func (m mapper) mapDomainSearchResultToSearchResult(dr *model.SearchResult) *SearchResult {
+	return &SearchResult{
+		Hits:   m.mapDomainSearchHitsToSearchHits(dr.Hits),
+		Total:  dr.Total,
+		Facets: m.mapDomainFacetsToFacets(dr.Facets),
+	}
+}
+
+func (m mapper) mapDomainSearchHitsToSearchHits(dhs []*model.SearchHit) []*SearchHit {
+	hs := []*SearchHit{}
+	for _, dh := range dhs {
+		hs = append(hs, &SearchHit{
+			Product:    *m.mapDomainProductToProduct(dh.Product),
+			Highlights: dh.Highlights,
+		})
+	}
+	return hs
+}
+
+func (m mapper) mapDomainFacetsToFacets(df *model.Facets) *Facets {
+	if df == nil {
+		return nil
+	}
+	return &Facets{
+		Categories: m.mapDomainBucketsToBuckets(df.Categories),
+		Brands:     m.mapDomainBucketsToBuckets(df.Brands),
+		Prices:     m.mapDomainBucketsToBuckets(df.Prices),
+		Ratings:    m.mapDomainBucketsToBuckets(df.Ratings),
+	}
+}
+
+func (m mapper) mapDomainBucketsToBuckets(dbs []*model.Bucket) []*Bucket {
+	bs := []*Bucket{}
+	for _, db := range dbs {
+		bs = append(bs, &Bucket{Key: db.Key, Count: db.Count, Selected: db.Selected})
+	}
+	return bs
+}
+
+func (m mapper) mapBulkOperationsToDomainBulkOperations(ops []*BulkOperation) []*model.BulkOperation {
+	dops := []*model.BulkOperation{}
+	for _, op := range ops {
+		if op == nil {
+			// reported as an unknown operation
+			op = &BulkOperation{}
+		}
+		dop := &model.BulkOperation{Type: op.Op}
+		if op.Product != nil {
+			dop.Product = &model.Product{
+				Name:     op.Product.Name,
+				Brand:    op.Product.Brand,
+				Price:    op.Product.Price,
+				Category: op.Product.Category,
+				Image:    op.Product.Image,
+			}
+		}
+		if op.Id != "" {
+			if dop.Product == nil {
+				dop.Product = &model.Product{}
+			}
+			dop.Product.Id = op.Id
+		}
+		dops = append(dops, dop)
+	}
+	return dops
+}
+
+func (m mapper) mapDomainBulkResultsToBulkReport(drs []*model.BulkResult) *BulkReport {
+	br := &BulkReport{Items: []*BulkResult{}}
+	for _, dr := range drs {
+		if dr.Error != "" {
+			br.Errors = true
+		}
+		br.Items = append(br.Items, &BulkResult{Op: dr.Type, Id: dr.Id, Status: dr.Status, Error: dr.Error})
+	}
+	return br
+}
+
+func (m mapper) mapDeadLettersToDeadLetters(dls []*amqpReceiver.DeadLetter) []*DeadLetter {
+	res := []*DeadLetter{}
+	for _, dl := range dls {
+		res = append(res, m.mapDeadLetterToDeadLetter(dl))
+	}
+	return res
+}
+
+func (m mapper) mapDeadLetterToDeadLetter(dl *amqpReceiver.DeadLetter) *DeadLetter {
+	headers := map[string]interface{}{}
+	for k, v := range dl.Headers {
+		headers[k] = v
+	}
+
+	body := string(dl.Body)
+	if !utf8.Valid(dl.Body) {
+		body = fmt.Sprintf("%x", dl.Body)
+	}
+
+	return &DeadLetter{
+		Id:        dl.Id,
+		Queue:     dl.Queue,
+		Exchange:  dl.Exchange,
+		Reason:    dl.Reason,
+		Attempts:  dl.Attempts,
+		Timestamp: dl.Timestamp,
+		Headers:   headers,
+		Body:      body,
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"github.com/gorilla/mux"
 	_ "github.com/pejovski/catalog/app/statik"
 	"github.com/pejovski/catalog/controller"
+	amqpReceiver "github.com/pejovski/catalog/receiver/amqp"
 	"github.com/rakyll/statik/fs"
 	"github.com/sirupsen/logrus"
 	"net/http"
@@ -20,12 +21,14 @@ type Router interface {
 type router struct {
 	router  *mux.Router
 	handler Handler
+	checks  map[string]HealthCheck
 }
 
-func newRouter(c controller.Controller) Router {
+func newRouter(c controller.Controller, checks map[string]HealthCheck) Router {
 	s := &router{
 		router:  mux.NewRouter(),
 		handler: newHandler(c),
+		checks:  checks,
 	}
 
 	s.health()
@@ -40,8 +43,11 @@ func (rtr *router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 }
 
 func (rtr *router) routes() {
-	rtr.router.Path("/products").Queries("category", "{category}").Methods("GET").HandlerFunc(rtr.handler.Products()).Name("products")
+	rtr.router.Path("/products").Methods("GET").HandlerFunc(rtr.handler.Products()).Name("products")
 	rtr.router.HandleFunc("/products", rtr.handler.CreateProduct()).Methods("POST")
+	rtr.router.HandleFunc("/products/bulk", rtr.handler.BulkProducts()).Methods("POST")
+	// must be registered before /products/{id} so "search" is not taken for an id
+	rtr.router.HandleFunc("/products/search", rtr.handler.SearchProducts()).Methods("GET")
 	rtr.router.HandleFunc("/products/{id}", rtr.handler.Product()).Methods("GET")
 	rtr.router.HandleFunc("/products/{id}", rtr.handler.UpdateProduct()).Methods("PUT")
 	rtr.router.HandleFunc("/products/{id}", rtr.handler.UpdateProductPrice()).Methods("PATCH")
@@ -61,8 +67,18 @@ func (rtr *router) swagger() {
 }
 
 func (rtr *router) health() {
-	rtr.router.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
-		w.WriteHeader(http.StatusOK)
-		_, _ = w.Write([]byte("Up"))
-	}).Methods("GET")
+	rtr.router.HandleFunc("/health", rtr.handler.Health(rtr.checks)).Methods("GET")
+}
+
+// newAdminRouter routes the admin endpoints, they are served on a listener of their own
+// so they are never exposed along with the products
+func newAdminRouter(dl amqpReceiver.DeadLetters) http.Handler {
+	r := mux.NewRouter()
+	h := newAdminHandler(dl)
+
+	r.HandleFunc("/admin/dlq", h.DeadLetters()).Methods("GET")
+	r.HandleFunc("/admin/dlq/replay", h.ReplayDeadLetters()).Methods("POST")
+	r.HandleFunc("/admin/dlq/purge", h.PurgeDeadLetters()).Methods("POST")
+
+	return r
 }
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"github.com/pejovski/catalog/controller"
+	amqpReceiver "github.com/pejovski/catalog/receiver/amqp"
 	srv "github.com/pejovski/catalog/server"
 	"github.com/sirupsen/logrus"
 	"net/http"
@@ -18,42 +19,79 @@ const (
 
 type server struct {
 	router Router
+	// nil when the admin endpoints are not served
+	admin     http.Handler
+	adminAddr string
 }
 
-func NewServer(c controller.Controller) srv.Server {
-	return server{router: newRouter(c)}
+// NewServer serves the api, checks are reported by the health endpoint. The admin endpoints manage
+// the dead-letter queues and are served on adminAddr (e.g. 127.0.0.1:8202), they are left out
+// when dl is nil or adminAddr is empty.
+func NewServer(c controller.Controller, checks map[string]HealthCheck, dl amqpReceiver.DeadLetters, adminAddr string) srv.Server {
+	s := server{router: newRouter(c, checks), adminAddr: adminAddr}
+	if dl != nil && adminAddr != "" {
+		s.admin = newAdminRouter(dl)
+	}
+	return s
 }
 
 func (s server) Run(ctx context.Context) {
-	server := &http.Server{
+	adminDone := make(chan struct{})
+
+	if s.admin != nil {
+		go func() {
+			defer close(adminDone)
+			serve(ctx, "Admin", &http.Server{
+				Handler:      s.admin,
+				Addr:         s.adminAddr,
+				ReadTimeout:  ReadTimeout,
+				WriteTimeout: WriteTimeout,
+			})
+		}()
+	} else {
+		close(adminDone)
+	}
+
+	serve(ctx, "API", &http.Server{
 		Handler:      s.router,
 		Addr:         fmt.Sprintf(":%s", os.Getenv("APP_PORT")),
 		ReadTimeout:  ReadTimeout,
 		WriteTimeout: WriteTimeout,
-	}
+	})
+
+	<-adminDone
+}
 
+// serve blocks until the server is shut down with ctx
+func serve(ctx context.Context, name string, server *http.Server) {
 	doneCh := make(chan struct{})
+	// requests are not tied to ctx, in-flight ones are drained by Shutdown so their writes are not cut halfway
+	shutdownCh := make(chan struct{})
 
 	go func() {
+		defer close(shutdownCh)
 		select {
 		case <-ctx.Done():
-			logrus.Info("API server is shutting down")
+			logrus.Infof("%s server is shutting down", name)
 			shutdownCtx, cancel := context.WithTimeout(
 				context.Background(),
 				time.Second*5,
 			)
 			defer cancel()
 			if err := server.Shutdown(shutdownCtx); err != nil {
-				logrus.Errorf("API Server error: %s", err)
+				logrus.Errorf("%s Server error: %s", name, err)
 			}
 		case <-doneCh:
 		}
 	}()
 
-	logrus.Infof("API Server started at port: %s", os.Getenv("APP_PORT"))
-	if err := server.ListenAndServe(); err != nil {
-		logrus.Errorf("API Server error: %s", err)
+	logrus.Infof("%s Server started at %s", name, server.Addr)
+	err := server.ListenAndServe()
+	if err != nil && err != http.ErrServerClosed {
+		logrus.Errorf("%s Server error: %s", name, err)
 	}
 
 	close(doneCh)
+	// ListenAndServe returns as soon as Shutdown starts, serve returns once the requests are drained
+	<-shutdownCh
 }